@@ -1,11 +1,31 @@
 package conditions
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrArgumentNotFound is matched by the errors of resolvers for keys
+// without any argument, so that IS NULL and EXISTS can tell an absent
+// argument from a failing resolver. Custom resolvers report absent keys
+// with an error for which errors.Is(err, ErrArgumentNotFound) is true.
+// Evaluations fail on such keys with a *VariableNotFoundError, which
+// matches ErrArgumentNotFound as well.
+var ErrArgumentNotFound = errors.New("argument not found")
 
 type ArgResolver interface {
 	Resolve(key string) (interface{}, error)
 }
 
+// ContextArgResolver is an ArgResolver which takes the context of the
+// evaluation, so that lookups can be canceled. EvaluateContext calls
+// ResolveContext instead of Resolve.
+type ContextArgResolver interface {
+	ArgResolver
+	ResolveContext(ctx context.Context, key string) (interface{}, error)
+}
+
 type MapArgResolver struct {
 	args map[string]interface{}
 }
@@ -21,5 +41,18 @@ func (r *MapArgResolver) Resolve(key string) (interface{}, error) {
 		return arg, nil
 	}
 
-	return nil, fmt.Errorf("argument by key %s not found", key)
+	return nil, &argumentNotFoundError{key: key}
+}
+
+// argumentNotFoundError is returned by MapArgResolver for absent keys.
+type argumentNotFoundError struct {
+	key string
+}
+
+func (e *argumentNotFoundError) Error() string {
+	return fmt.Sprintf("argument by key %s not found", e.key)
+}
+
+func (e *argumentNotFoundError) Is(target error) bool {
+	return target == ErrArgumentNotFound
 }
@@ -18,6 +18,9 @@ const (
 	String   = DataType("string")
 	Time     = DataType("time")
 	Duration = DataType("duration")
+	Null     = DataType("null")
+	Regex    = DataType("regex")
+	Array    = DataType("array")
 )
 
 // InspectDataType returns the data type of a given value.
@@ -47,13 +50,19 @@ type Node interface {
 func (_ *VarRef) node()             {}
 func (_ *NumberLiteral) node()      {}
 func (_ *StringLiteral) node()      {}
+func (_ *RegexLiteral) node()       {}
 func (_ *BooleanLiteral) node()     {}
+func (_ *NullLiteral) node()        {}
 func (_ *TimeLiteral) node()        {}
 func (_ *DurationLiteral) node()    {}
 func (_ *BinaryExpr) node()         {}
+func (_ *UnaryExpr) node()          {}
+func (_ *BetweenExpr) node()        {}
+func (_ *CallExpr) node()           {}
 func (_ *ParenExpr) node()          {}
 func (_ *SliceStringLiteral) node() {}
 func (_ *SliceNumberLiteral) node() {}
+func (_ *BadExpr) node()            {}
 
 // Expr represents an expression that can be evaluated to a value.
 type Expr interface {
@@ -65,13 +74,19 @@ type Expr interface {
 func (_ *VarRef) expr()             {}
 func (_ *NumberLiteral) expr()      {}
 func (_ *StringLiteral) expr()      {}
+func (_ *RegexLiteral) expr()       {}
 func (_ *BooleanLiteral) expr()     {}
+func (_ *NullLiteral) expr()        {}
 func (_ *TimeLiteral) expr()        {}
 func (_ *DurationLiteral) expr()    {}
 func (_ *BinaryExpr) expr()         {}
+func (_ *UnaryExpr) expr()          {}
+func (_ *BetweenExpr) expr()        {}
+func (_ *CallExpr) expr()           {}
 func (_ *ParenExpr) expr()          {}
 func (_ *SliceStringLiteral) expr() {}
 func (_ *SliceNumberLiteral) expr() {}
+func (_ *BadExpr) expr()            {}
 
 // VarRef represents a reference to a variable.
 type VarRef struct {
@@ -85,13 +100,38 @@ func (r *VarRef) Args() []string {
 	return []string{r.Val}
 }
 
-// NumberLiteral represents a numeric literal.
+// NumberKind tells which field of a NumberLiteral holds its exact value.
+type NumberKind uint8
+
+const (
+	// NumberFloat numbers are held by Val.
+	NumberFloat NumberKind = iota
+	// NumberInt numbers are integers held by Int.
+	NumberInt
+	// NumberUint numbers are integers above math.MaxInt64 held by Uint.
+	NumberUint
+)
+
+// NumberLiteral represents a numeric literal. Integers are held exactly
+// by Int or Uint, as told by Kind, so that 64-bit IDs compare exactly
+// with each other. Val holds the nearest float64 of any number.
 type NumberLiteral struct {
-	Val float64
+	Val  float64
+	Kind NumberKind
+	Int  int64
+	Uint uint64
 }
 
 // String returns a string representation of the literal.
-func (l *NumberLiteral) String() string { return strconv.FormatFloat(l.Val, 'f', 3, 64) }
+func (l *NumberLiteral) String() string {
+	switch l.Kind {
+	case NumberInt:
+		return strconv.FormatInt(l.Int, 10) + ".000"
+	case NumberUint:
+		return strconv.FormatUint(l.Uint, 10) + ".000"
+	}
+	return strconv.FormatFloat(l.Val, 'f', 3, 64)
+}
 
 func (n *NumberLiteral) Args() []string {
 	args := []string{}
@@ -110,7 +150,23 @@ func NewSliceStringLiteral(val []string) *SliceStringLiteral {
 
 type SliceStringLiteral struct {
 	Val []string
-	m   map[string]struct{}
+	// set of the items, nil for arguments which are scanned instead
+	m map[string]struct{}
+}
+
+// has reports whether the slice holds val. Arguments are scanned, large
+// lists are looked up faster as a StringCollection.
+func (l *SliceStringLiteral) has(val string) bool {
+	if l.m != nil {
+		_, found := l.m[val]
+		return found
+	}
+	for _, item := range l.Val {
+		if item == val {
+			return true
+		}
+	}
+	return false
 }
 
 // String returns a string representation of the literal.
@@ -125,11 +181,53 @@ func (l *SliceStringLiteral) Args() []string {
 
 type SliceNumberLiteral struct {
 	Val []float64
+	// exact numbers of the items, nil unless some are integers
+	nums []NumberLiteral
+}
+
+// newSliceNumberLiteral returns the slice of nums, which keeps integers
+// exact.
+func newSliceNumberLiteral(nums []NumberLiteral) *SliceNumberLiteral {
+	l := &SliceNumberLiteral{Val: make([]float64, len(nums))}
+	for i, n := range nums {
+		l.Val[i] = n.Val
+		if n.isInteger() {
+			l.nums = nums
+		}
+	}
+	return l
+}
+
+// has reports whether the slice holds number, exactly for integers and
+// within epsilon otherwise.
+func (l *SliceNumberLiteral) has(number *NumberLiteral, epsilon float64) bool {
+	if l.nums != nil {
+		for i := range l.nums {
+			if numberEqual(number, &l.nums[i], epsilon) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, item := range l.Val {
+		if float64Equal(number.Val, item, epsilon) {
+			return true
+		}
+	}
+	return false
 }
 
-// String returns a string representation of the literal.
+// String returns a string representation of the literal, which keeps
+// integers exact.
 func (l *SliceNumberLiteral) String() string {
-	return fmt.Sprintf("%v", l.Val)
+	if l.nums == nil {
+		return fmt.Sprintf("%v", l.Val)
+	}
+	items := make([]string, len(l.nums))
+	for i := range l.nums {
+		items[i] = formatNumber(&l.nums[i])
+	}
+	return "[" + strings.Join(items, " ") + "]"
 }
 
 func (l *SliceNumberLiteral) Args() []string {
@@ -155,6 +253,53 @@ func (l *BooleanLiteral) Args() []string {
 	return args
 }
 
+// RegexLiteral represents the compiled pattern of a =~ or !~ operation, or
+// of a LIKE, ILIKE or MATCHES operation.
+type RegexLiteral struct {
+	Val *regexp.Regexp
+	// LIKE or MATCHES pattern Val was translated from, nil for =~ and !~
+	pattern *StringLiteral
+}
+
+// String returns a string representation of the literal.
+func (l *RegexLiteral) String() string {
+	if l.pattern != nil {
+		return l.pattern.String()
+	}
+
+	var b strings.Builder
+	b.WriteByte('/')
+	escaped := false
+	for _, c := range l.Val.String() {
+		if c == '/' && !escaped {
+			b.WriteByte('\\')
+		}
+		escaped = c == '\\' && !escaped
+		b.WriteRune(c)
+	}
+	b.WriteByte('/')
+	return b.String()
+}
+
+func (l *RegexLiteral) Args() []string {
+	args := []string{}
+	return args
+}
+
+// NullLiteral represents the NULL literal, which is also the value of a
+// variable set to nil.
+type NullLiteral struct{}
+
+// String returns a string representation of the literal.
+func (l *NullLiteral) String() string {
+	return "NULL"
+}
+
+func (l *NullLiteral) Args() []string {
+	args := []string{}
+	return args
+}
+
 // StringLiteral represents a string literal.
 type StringLiteral struct {
 	Val string
@@ -174,7 +319,12 @@ func (l *StringLiteral) Args() []string {
 }
 
 // String returns a string representation of the literal.
-func (l *TimeLiteral) String() string { return l.Val.UTC().Format("2006-01-02 15:04:05.999") }
+func (l *TimeLiteral) String() string { return l.Val.UTC().Format(time.RFC3339Nano) }
+
+func (l *TimeLiteral) Args() []string {
+	args := []string{}
+	return args
+}
 
 // DurationLiteral represents a duration literal.
 type DurationLiteral struct {
@@ -184,6 +334,11 @@ type DurationLiteral struct {
 // String returns a string representation of the literal.
 func (l *DurationLiteral) String() string { return FormatDuration(l.Val) }
 
+func (l *DurationLiteral) Args() []string {
+	args := []string{}
+	return args
+}
+
 // BinaryExpr represents an operation between two expressions.
 type BinaryExpr struct {
 	Op  Token
@@ -205,6 +360,67 @@ func (e *BinaryExpr) Args() []string {
 	return args
 }
 
+// UnaryExpr represents an operation applied to a single expression.
+type UnaryExpr struct {
+	Op   Token
+	Expr Expr
+}
+
+// String returns a string representation of the unary expression.
+func (e *UnaryExpr) String() string {
+	return fmt.Sprintf("%s %s", e.Op, e.Expr.String())
+}
+
+func (e *UnaryExpr) Args() []string {
+	return e.Expr.Args()
+}
+
+// BetweenExpr represents a range check of an expression against
+// inclusive bounds, negated when Op is NOTBETWEEN.
+type BetweenExpr struct {
+	Op    Token
+	Expr  Expr
+	Lower Expr
+	Upper Expr
+}
+
+// String returns a string representation of the range check.
+func (e *BetweenExpr) String() string {
+	return fmt.Sprintf("%s %s %s AND %s", e.Expr.String(), e.Op, e.Lower.String(), e.Upper.String())
+}
+
+func (e *BetweenExpr) Args() []string {
+	args := append([]string{}, e.Expr.Args()...)
+	args = append(args, e.Lower.Args()...)
+	return append(args, e.Upper.Args()...)
+}
+
+// CallExpr represents a function call.
+type CallExpr struct {
+	Name      string
+	Arguments []Expr
+
+	// function bound by the parser, if any
+	fn *Function
+}
+
+// String returns a string representation of the call.
+func (e *CallExpr) String() string {
+	args := make([]string, len(e.Arguments))
+	for i, arg := range e.Arguments {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", e.Name, strings.Join(args, ", "))
+}
+
+func (e *CallExpr) Args() []string {
+	args := []string{}
+	for _, arg := range e.Arguments {
+		args = append(args, arg.Args()...)
+	}
+	return args
+}
+
 // ParenExpr represents a parenthesized expression.
 type ParenExpr struct {
 	Expr Expr
@@ -220,6 +436,19 @@ func (p *ParenExpr) Args() []string {
 	return args
 }
 
+// BadExpr is a placeholder for an expression containing syntax errors,
+// produced by Parser.ParseAll.
+type BadExpr struct {
+	Err *ParseError
+}
+
+// String returns a string representation of the bad expression.
+func (e *BadExpr) String() string { return "<bad expression>" }
+
+func (e *BadExpr) Args() []string {
+	return []string{}
+}
+
 // Visitor can be called by Walk to traverse an AST hierarchy.
 // The Visit() function is called once per node.
 type Visitor interface {
@@ -237,6 +466,19 @@ func Walk(v Visitor, node Node) {
 		Walk(v, n.LHS)
 		Walk(v, n.RHS)
 
+	case *UnaryExpr:
+		Walk(v, n.Expr)
+
+	case *BetweenExpr:
+		Walk(v, n.Expr)
+		Walk(v, n.Lower)
+		Walk(v, n.Upper)
+
+	case *CallExpr:
+		for _, arg := range n.Arguments {
+			Walk(v, arg)
+		}
+
 	case *ParenExpr:
 		Walk(v, n.Expr)
 	}
@@ -279,7 +521,57 @@ func FormatDuration(d time.Duration) string {
 		return fmt.Sprintf("%ds", d/time.Second)
 	} else if d%time.Millisecond == 0 {
 		return fmt.Sprintf("%dms", d/time.Millisecond)
+	} else if d%time.Microsecond == 0 {
+		return fmt.Sprintf("%dus", d/time.Microsecond)
 	} else {
-		return fmt.Sprintf("%d", d/time.Microsecond)
+		return fmt.Sprintf("%dns", d)
+	}
+}
+
+// durationUnits maps the units of duration literals to their length.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// ParseDuration parses a duration such as "15m", "1h30m" or "7d", the
+// reverse of FormatDuration. Valid units are "ns", "us" (or "µs"), "ms",
+// "s", "m", "h", "d" and "w".
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	var d time.Duration
+	for rest := s; rest != ""; {
+		i := strings.IndexFunc(rest, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.ParseFloat(rest[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		rest = rest[i:]
+
+		j := strings.IndexFunc(rest, func(r rune) bool { return r >= '0' && r <= '9' || r == '.' })
+		if j < 0 {
+			j = len(rest)
+		}
+		unit, ok := durationUnits[rest[:j]]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit %q in duration %q", rest[:j], s)
+		}
+		rest = rest[j:]
+
+		d += time.Duration(n * float64(unit))
 	}
+	return d, nil
 }
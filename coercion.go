@@ -0,0 +1,100 @@
+package conditions
+
+// Coercion selects whether operands of different types are converted to
+// a common type before they are compared.
+type Coercion int
+
+const (
+	// CoercionNone compares operands as they are, so that comparing a
+	// string with a number fails. It is the default.
+	CoercionNone Coercion = iota
+	// CoercionStrings converts strings, e.g. from query strings or CSV
+	// files, to the type of the operand they are compared with:
+	//
+	//   - ==, !=, <, <=, >, >= and BETWEEN convert a string to a number if
+	//     the other operand is a number and the string parses as one, and
+	//     "true" or "false" to a boolean if the other operand is a boolean
+	//   - IN and CONTAINS convert the looked up value to a number for
+	//     collections of numbers, and a number to a string for collections
+	//     of strings
+	//   - =~ and !~ match numbers as strings
+	//
+	// Numbers are converted to strings in their shortest representation,
+	// so 1.0 is "1". Operands which cannot be converted are compared as
+	// they are.
+	CoercionStrings
+)
+
+// WithCoercion sets how operands of different types are compared,
+// CoercionNone by default.
+func WithCoercion(mode Coercion) EvalOption {
+	return func(c *evalConfig) {
+		c.coercion = mode
+	}
+}
+
+// coerce converts the operands of op following the rules of
+// CoercionStrings.
+func coerce(op Token, l, r Expr) (Expr, Expr) {
+	switch op {
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		return coercePair(l, r)
+	case IN, NOTIN:
+		return coerceItem(l, r), r
+	case CONTAINS, NOTCONTAINS:
+		return l, coerceItem(r, l)
+	case EREG, NEREG:
+		return numberToString(l), r
+	}
+	return l, r
+}
+
+// coercePair converts a string operand to the type of the other operand.
+func coercePair(l, r Expr) (Expr, Expr) {
+	if s, ok := l.(*StringLiteral); ok {
+		return coerceString(s, r), r
+	}
+	if s, ok := r.(*StringLiteral); ok {
+		return l, coerceString(s, l)
+	}
+	return l, r
+}
+
+// coerceString converts s to a number or a boolean if other is one.
+func coerceString(s *StringLiteral, other Expr) Expr {
+	switch other.(type) {
+	case *NumberLiteral:
+		if n, err := parseNumber(s.Val); err == nil {
+			return &n
+		}
+	case *BooleanLiteral:
+		switch s.Val {
+		case "true":
+			return &BooleanLiteral{Val: true}
+		case "false":
+			return &BooleanLiteral{Val: false}
+		}
+	}
+	return s
+}
+
+// coerceItem converts v to the type of the items of the collection c.
+func coerceItem(v, c Expr) Expr {
+	switch c.(type) {
+	case *SliceNumberLiteral, *NumberCollectionLiteral:
+		if s, ok := v.(*StringLiteral); ok {
+			return coerceString(s, &NumberLiteral{})
+		}
+	case *SliceStringLiteral, *StringCollectionLiteral:
+		return numberToString(v)
+	}
+	return v
+}
+
+// numberToString converts a number to a string.
+func numberToString(v Expr) Expr {
+	if n, ok := v.(*NumberLiteral); ok {
+		return &StringLiteral{Val: formatNumber(n)}
+	}
+	return v
+}
@@ -2,6 +2,7 @@ package conditions
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 )
 
@@ -21,7 +22,14 @@ type StringCollection interface {
 }
 
 type MapNumberCollection struct {
+	// float64 of every item, looked up by Has
 	items map[float64]bool
+	// Integer items are looked up exactly in ints and uints, float items
+	// in floats. All items are floats if floats is nil, as for
+	// NewMapNumberCollectionFromMap.
+	ints   map[int64]bool
+	uints  map[uint64]bool
+	floats map[float64]bool
 }
 
 func NewMapNumberCollectionFromMap(items map[float64]bool) Collection {
@@ -29,24 +37,68 @@ func NewMapNumberCollectionFromMap(items map[float64]bool) Collection {
 }
 
 func NewMapNumberCollection(items []interface{}) Collection {
-	itemMap := make(map[float64]bool)
+	c := &MapNumberCollection{items: make(map[float64]bool), floats: make(map[float64]bool)}
 
 	for _, item := range items {
 		switch i := item.(type) {
 		case float64:
-			itemMap[i] = true
+			c.addFloat(i)
 		case float32:
-			itemMap[float64(i)] = true
+			c.addFloat(float64(i))
 		case int64:
-			itemMap[float64(i)] = true
+			c.addInteger(intNumber(i))
 		case int32:
-			itemMap[float64(i)] = true
+			c.addInteger(intNumber(int64(i)))
 		case int:
-			itemMap[float64(i)] = true
+			c.addInteger(intNumber(int64(i)))
+		case uint64:
+			c.addInteger(uintNumber(i))
 		}
 	}
 
-	return NewMapNumberCollectionFromMap(itemMap)
+	return c
+}
+
+func (c *MapNumberCollection) addInteger(n NumberLiteral) {
+	if n.Kind == NumberUint {
+		if c.uints == nil {
+			c.uints = make(map[uint64]bool)
+		}
+		c.uints[n.Uint] = true
+	} else {
+		if c.ints == nil {
+			c.ints = make(map[int64]bool)
+		}
+		c.ints[n.Int] = true
+	}
+	c.items[n.Val] = true
+}
+
+func (c *MapNumberCollection) addFloat(f float64) {
+	c.floats[f] = true
+	c.items[f] = true
+}
+
+// hasFloat reports whether f is a float item.
+func (c *MapNumberCollection) hasFloat(f float64) bool {
+	if c.floats == nil {
+		_, exists := c.items[f]
+		return exists
+	}
+	return c.floats[f]
+}
+
+// hasInteger reports whether an integer item equals the float f exactly.
+func (c *MapNumberCollection) hasInteger(f float64) bool {
+	switch {
+	case f != math.Trunc(f):
+		return false
+	case f >= math.MinInt64 && f < math.MaxInt64:
+		return c.ints[int64(f)]
+	case f >= math.MaxInt64 && f < math.MaxUint64:
+		return c.uints[uint64(f)]
+	}
+	return false
 }
 
 func (c *MapNumberCollection) Has(number float64) bool {
@@ -55,6 +107,17 @@ func (c *MapNumberCollection) Has(number float64) bool {
 	return exists
 }
 
+// hasWithin looks integers up exactly, and other numbers like Has.
+func (c *MapNumberCollection) hasWithin(number NumberLiteral, epsilon float64) bool {
+	switch number.Kind {
+	case NumberInt:
+		return c.ints[number.Int] || c.hasFloat(number.Val)
+	case NumberUint:
+		return c.uints[number.Uint] || c.hasFloat(number.Val)
+	}
+	return c.Has(number.Val)
+}
+
 func (c *MapNumberCollection) String() string {
 	str := "["
 
@@ -67,8 +130,20 @@ func (c *MapNumberCollection) String() string {
 	return str
 }
 
+// Count counts integer items exactly, so that integers sharing their
+// nearest float64 count apart, and float items unless an integer equals
+// them.
 func (c *MapNumberCollection) Count() int {
-	return len(c.items)
+	if c.floats == nil {
+		return len(c.items)
+	}
+	n := len(c.ints) + len(c.uints)
+	for f := range c.floats {
+		if !c.hasInteger(f) {
+			n++
+		}
+	}
+	return n
 }
 
 type MapStringCollection struct {
@@ -118,6 +193,8 @@ func NewCollection(items []interface{}) (Collection, error) {
 			return NewMapNumberCollection(items), nil
 		case int32:
 			return NewMapNumberCollection(items), nil
+		case uint64:
+			return NewMapNumberCollection(items), nil
 		case float32:
 			return NewMapNumberCollection(items), nil
 		case float64:
@@ -141,3 +218,143 @@ func TryNewCollection(items []interface{}) Collection {
 
 	return nil
 }
+
+// intSlice, int32Slice, int64Slice and float32Slice are number
+// collections over slice arguments, which are scanned in place instead of
+// being copied to a slice of float64.
+type (
+	intSlice     []int
+	int32Slice   []int32
+	int64Slice   []int64
+	float32Slice []float32
+)
+
+// numberLookup is implemented by the number collections which compare
+// integers exactly, and other numbers within the epsilon of the
+// evaluation.
+type numberLookup interface {
+	hasWithin(number NumberLiteral, epsilon float64) bool
+}
+
+// numberSlice is implemented by the number collections over slices.
+type numberSlice interface {
+	NumberCollection
+	numberLookup
+	// float64s copies the numbers, for functions taking []float64
+	float64s() []float64
+}
+
+// hasNumber reports whether the collection holds number, exactly for
+// integers in slices and MapNumberCollection. Other collections decide on
+// their own.
+func hasNumber(c NumberCollection, number *NumberLiteral, epsilon float64) bool {
+	if l, ok := c.(numberLookup); ok {
+		return l.hasWithin(*number, epsilon)
+	}
+	return c.Has(number.Val)
+}
+
+func (s intSlice) hasWithin(number NumberLiteral, epsilon float64) bool {
+	for _, item := range s {
+		n := intNumber(int64(item))
+		if numberEqual(&number, &n, epsilon) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s int32Slice) hasWithin(number NumberLiteral, epsilon float64) bool {
+	for _, item := range s {
+		n := intNumber(int64(item))
+		if numberEqual(&number, &n, epsilon) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s int64Slice) hasWithin(number NumberLiteral, epsilon float64) bool {
+	for _, item := range s {
+		n := intNumber(item)
+		if numberEqual(&number, &n, epsilon) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s float32Slice) hasWithin(number NumberLiteral, epsilon float64) bool {
+	for _, item := range s {
+		if float64Equal(number.Val, float64(item), epsilon) {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether the slice holds number exactly. Evaluations look
+// numbers up within their epsilon through hasWithin instead.
+func (s intSlice) Has(number float64) bool {
+	return s.hasWithin(NumberLiteral{Val: number}, 0)
+}
+
+func (s int32Slice) Has(number float64) bool {
+	return s.hasWithin(NumberLiteral{Val: number}, 0)
+}
+
+func (s int64Slice) Has(number float64) bool {
+	return s.hasWithin(NumberLiteral{Val: number}, 0)
+}
+
+// Has reports whether the slice holds number at the precision of float32.
+func (s float32Slice) Has(number float64) bool {
+	for _, item := range s {
+		if item == float32(number) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s intSlice) String() string     { return fmt.Sprintf("%v", []int(s)) }
+func (s int32Slice) String() string   { return fmt.Sprintf("%v", []int32(s)) }
+func (s int64Slice) String() string   { return fmt.Sprintf("%v", []int64(s)) }
+func (s float32Slice) String() string { return fmt.Sprintf("%v", []float32(s)) }
+
+func (s intSlice) Count() int     { return len(s) }
+func (s int32Slice) Count() int   { return len(s) }
+func (s int64Slice) Count() int   { return len(s) }
+func (s float32Slice) Count() int { return len(s) }
+
+func (s intSlice) float64s() []float64 {
+	f := make([]float64, len(s))
+	for i, item := range s {
+		f[i] = float64(item)
+	}
+	return f
+}
+
+func (s int32Slice) float64s() []float64 {
+	f := make([]float64, len(s))
+	for i, item := range s {
+		f[i] = float64(item)
+	}
+	return f
+}
+
+func (s int64Slice) float64s() []float64 {
+	f := make([]float64, len(s))
+	for i, item := range s {
+		f[i] = float64(item)
+	}
+	return f
+}
+
+func (s float32Slice) float64s() []float64 {
+	f := make([]float64, len(s))
+	for i, item := range s {
+		f[i] = float64(item)
+	}
+	return f
+}
@@ -0,0 +1,52 @@
+package conditions
+
+import (
+	"context"
+	"fmt"
+)
+
+// CanceledError is returned by evaluations stopped because their context
+// is done. It wraps the error of the context, so that errors.Is(err,
+// context.DeadlineExceeded) tells a timeout from a cancellation.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("evaluation canceled: %v", e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// EvaluateContext takes an expr and evaluates it using given arg resolver
+// like EvaluateWithArgResolver. The context is checked before each node
+// is evaluated and passed to resolvers implementing ContextArgResolver;
+// once it is done the evaluation stops with a *CanceledError.
+func EvaluateContext(ctx context.Context, expr Expr, args ArgResolver, opts ...EvalOption) (bool, error) {
+	return NewEvaluator(opts...).EvaluateContext(ctx, expr, args)
+}
+
+// EvaluateContext takes an expr and evaluates it using given arg resolver
+// until ctx is done, see the package function of the same name.
+func (e *Evaluator) EvaluateContext(ctx context.Context, expr Expr, args ArgResolver) (bool, error) {
+	ev, err := e.start(expr, args)
+	if err != nil {
+		return false, err
+	}
+	ev.ctx = ctx
+	return ev.evaluate(expr)
+}
+
+// canceled returns a *CanceledError if the context of the evaluation is
+// done.
+func (ev *evaluation) canceled() error {
+	if ev.ctx == nil {
+		return nil
+	}
+	if err := ev.ctx.Err(); err != nil {
+		return &CanceledError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package conditions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slowResolver resolves its arguments once the delay has passed, unless
+// the context is done first.
+type slowResolver struct {
+	args  map[string]interface{}
+	delay time.Duration
+}
+
+func (r *slowResolver) Resolve(key string) (interface{}, error) {
+	return r.ResolveContext(context.Background(), key)
+}
+
+func (r *slowResolver) ResolveContext(ctx context.Context, key string) (interface{}, error) {
+	select {
+	case <-time.After(r.delay):
+		return NewMapArgResolver(r.args).Resolve(key)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// cancelingResolver cancels the evaluation after resolving its first key.
+type cancelingResolver struct {
+	countingResolver
+	cancel context.CancelFunc
+}
+
+func (r *cancelingResolver) Resolve(key string) (interface{}, error) {
+	defer r.cancel()
+	return r.countingResolver.Resolve(key)
+}
+
+func TestEvaluateContext(t *testing.T) {
+	expr := mustParse(t, `{a} AND {b}`)
+	args := map[string]interface{}{"a": true, "b": true}
+
+	r, err := EvaluateContext(context.Background(), expr, NewMapArgResolver(args))
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = EvaluateContext(ctx, expr, NewMapArgResolver(args))
+	var canceled *CanceledError
+	assert.True(t, errors.As(err, &canceled))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.EqualError(t, err, "evaluation canceled: context canceled")
+
+	// The deadline reaches the resolver.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = EvaluateContext(ctx, expr, &slowResolver{args: args, delay: time.Minute})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, errors.As(err, &canceled))
+	assert.True(t, time.Since(start) < time.Minute)
+
+	// Cancellation is checked between nodes and not mistaken for a
+	// missing variable.
+	ctx, cancel = context.WithCancel(context.Background())
+	resolver := &cancelingResolver{countingResolver: countingResolver{args: args}, cancel: cancel}
+	_, err = EvaluateContext(ctx, expr, resolver, WithMissingVariables(MissingVariableFalse))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, []string{"a"}, resolver.resolved)
+}
+
+func TestProgramEvalContext(t *testing.T) {
+	prog, err := Compile(mustParse(t, `{a} AND {b}`))
+	assert.NoError(t, err)
+	args := map[string]interface{}{"a": true, "b": true}
+
+	r, err := prog.EvalContext(context.Background(), NewMapArgResolver(args))
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resolver := &cancelingResolver{countingResolver: countingResolver{args: args}, cancel: cancel}
+	_, err = prog.EvalContext(ctx, resolver)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, []string{"a"}, resolver.resolved)
+
+	// Evaluations without context are not affected by earlier ones.
+	r, err = prog.Eval(NewMapArgResolver(args))
+	assert.NoError(t, err)
+	assert.True(t, r)
+}
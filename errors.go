@@ -0,0 +1,144 @@
+package conditions
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// VariableNotFoundError is returned when a variable has no argument and
+// no default. It wraps the error of the resolver and so matches
+// ErrArgumentNotFound.
+type VariableNotFoundError struct {
+	Name string
+	Err  error
+}
+
+func (e *VariableNotFoundError) Error() string {
+	return fmt.Sprintf("argument %v not resolved: %v", e.Name, e.Err)
+}
+
+func (e *VariableNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveError is returned when the resolver fails to resolve a variable
+// for any other reason than the absence of its argument.
+type ResolveError struct {
+	Name string
+	Err  error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("argument %v not resolved: %v", e.Name, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// TypeMismatchError is returned when an operator is applied to operands
+// of types it does not support. Right is Unknown for unary operators.
+type TypeMismatchError struct {
+	Op          Token
+	Left, Right DataType
+	// Node is the failing expression
+	Node Expr
+
+	unary bool
+}
+
+func (e *TypeMismatchError) Error() string {
+	var msg string
+	if e.unary {
+		msg = fmt.Sprintf("cannot apply %s to %s", e.Op, typeName(e.Left))
+	} else {
+		msg = fmt.Sprintf("cannot apply %s to %s and %s", e.Op, typeName(e.Left), typeName(e.Right))
+	}
+	if e.Node != nil {
+		msg += " in " + e.Node.String()
+	}
+	return msg
+}
+
+// UnsupportedArgumentError is returned when the argument of a variable has
+// a Go type which cannot be evaluated.
+type UnsupportedArgumentError struct {
+	Name   string
+	GoType reflect.Type
+}
+
+func (e *UnsupportedArgumentError) Error() string {
+	return fmt.Sprintf("unsupported argument %s of type %s", e.Name, e.GoType)
+}
+
+// typeMismatch returns the error of an operation on l and r, nil for
+// unary operations. The operator and the node are filled in by the
+// callers.
+func typeMismatch(l, r Expr) error {
+	return &TypeMismatchError{Left: dataTypeOf(l), Right: dataTypeOf(r)}
+}
+
+// withOperator attributes a type mismatch to the binary operator applied
+// to l and r.
+func withOperator(err error, op Token, l, r Expr) error {
+	var tm *TypeMismatchError
+	if errors.As(err, &tm) && tm.Op == ILLEGAL {
+		tm.Op, tm.Left, tm.Right = op, dataTypeOf(l), dataTypeOf(r)
+	}
+	return err
+}
+
+// withUnaryOperator attributes a type mismatch to the unary operator
+// applied to v.
+func withUnaryOperator(err error, op Token, v Expr) error {
+	var tm *TypeMismatchError
+	if errors.As(err, &tm) && tm.Op == ILLEGAL {
+		tm.Op, tm.Left, tm.Right, tm.unary = op, dataTypeOf(v), Unknown, true
+	}
+	return err
+}
+
+// withNode attributes a type mismatch to the failing node, unless a node
+// within it failed.
+func withNode(err error, n Expr) error {
+	var tm *TypeMismatchError
+	if errors.As(err, &tm) && tm.Node == nil {
+		tm.Node = n
+	}
+	return err
+}
+
+// dataTypeOf returns the data type of a literal.
+func dataTypeOf(e Expr) DataType {
+	switch n := e.(type) {
+	case *NumberLiteral:
+		return Number
+	case *BooleanLiteral:
+		return Boolean
+	case *StringLiteral:
+		return String
+	case *TimeLiteral:
+		return Time
+	case *DurationLiteral:
+		return Duration
+	case *NullLiteral:
+		return Null
+	case *RegexLiteral:
+		if n.pattern != nil {
+			return String
+		}
+		return Regex
+	case *SliceStringLiteral, *SliceNumberLiteral, *StringCollectionLiteral, *NumberCollectionLiteral:
+		return Array
+	}
+	return Unknown
+}
+
+// typeName returns the name of the data type in error messages.
+func typeName(t DataType) string {
+	if t == Unknown {
+		return "unknown"
+	}
+	return string(t)
+}
@@ -0,0 +1,78 @@
+package conditions
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedErrors(t *testing.T) {
+	_, err := Evaluate(mustParse(t, `{a} == 1 AND {b} > 2`), map[string]interface{}{"a": 1})
+	var notFound *VariableNotFoundError
+	assert.True(t, errors.As(err, &notFound))
+	assert.Equal(t, "b", notFound.Name)
+	assert.True(t, errors.Is(err, ErrArgumentNotFound))
+	assert.EqualError(t, err, "argument b not resolved: argument by key b not found")
+
+	failing := resolverFunc(func(key string) (interface{}, error) { return nil, errors.New("timeout") })
+	_, err = EvaluateWithArgResolver(mustParse(t, `{a}`), failing)
+	var resolveErr *ResolveError
+	assert.True(t, errors.As(err, &resolveErr))
+	assert.Equal(t, "a", resolveErr.Name)
+	assert.False(t, errors.Is(err, ErrArgumentNotFound))
+
+	_, err = Evaluate(mustParse(t, `{a} == 1`), map[string]interface{}{"a": struct{}{}})
+	var unsupported *UnsupportedArgumentError
+	assert.True(t, errors.As(err, &unsupported))
+	assert.Equal(t, "a", unsupported.Name)
+	assert.Equal(t, reflect.TypeOf(struct{}{}), unsupported.GoType)
+	assert.EqualError(t, err, "unsupported argument a of type struct {}")
+
+	_, err = Evaluate(mustParse(t, `1 IN {a}`), map[string]interface{}{"a": []interface{}{1, "x"}})
+	assert.True(t, errors.As(err, &unsupported))
+	assert.EqualError(t, err, "unsupported argument a of type []interface {}")
+
+	var tests = []struct {
+		cond        string
+		args        map[string]interface{}
+		op          Token
+		left, right DataType
+		node        string
+		msg         string
+	}{
+		{`{a} == 1 OR {b} > "x"`, map[string]interface{}{"a": 2, "b": 3}, GT, Number, String, `b > "x"`,
+			`cannot apply > to number and string in b > "x"`},
+		{`{tags} CONTAINS 1`, map[string]interface{}{"tags": []string{"x"}}, CONTAINS, Array, Number, `tags CONTAINS 1.000`,
+			`cannot apply CONTAINS to array and number in tags CONTAINS 1.000`},
+		{`NOT {a}`, map[string]interface{}{"a": "x"}, NOT, String, Unknown, `NOT a`,
+			`cannot apply NOT to string in NOT a`},
+		{`-{a} > 1`, map[string]interface{}{"a": true}, SUB, Boolean, Unknown, `- a`,
+			`cannot apply - to boolean in - a`},
+		{`{a} BETWEEN 1 AND "z"`, map[string]interface{}{"a": 1}, BETWEEN, Number, String, `a BETWEEN 1.000 AND "z"`,
+			`cannot apply BETWEEN to number and string in a BETWEEN 1.000 AND "z"`},
+		{`({a} + 1) * 2 == 4`, map[string]interface{}{"a": "x"}, ADD, String, Number, `a + 1.000`,
+			`cannot apply + to string and number in a + 1.000`},
+	}
+
+	for _, test := range tests {
+		expr := mustParse(t, test.cond)
+		_, err := Evaluate(expr, test.args)
+		var mismatch *TypeMismatchError
+		if !assert.True(t, errors.As(err, &mismatch), test.cond) {
+			continue
+		}
+		assert.Equal(t, test.op, mismatch.Op, test.cond)
+		assert.Equal(t, test.left, mismatch.Left, test.cond)
+		assert.Equal(t, test.right, mismatch.Right, test.cond)
+		assert.Equal(t, test.node, mismatch.Node.String(), test.cond)
+		assert.EqualError(t, err, test.msg, test.cond)
+
+		// Compiled programs fail alike.
+		prog, err := Compile(expr)
+		assert.NoError(t, err, test.cond)
+		_, err = prog.Eval(NewMapArgResolver(test.args))
+		assert.EqualError(t, err, test.msg, test.cond)
+	}
+}
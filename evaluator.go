@@ -1,11 +1,15 @@
 package conditions
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
 	"regexp"
+	"strings"
+	"time"
 )
 
 var (
@@ -13,26 +17,259 @@ var (
 	defaultEpsilon = float64(1e-6)
 )
 
-// SetDefaultEpsilon sets the defaultEpsilon
+// SetDefaultEpsilon sets the defaultEpsilon, the tolerance of number
+// comparisons of the evaluators created afterwards without WithEpsilon.
+//
+// Deprecated: the setting is global and races with concurrent
+// evaluations. Use WithEpsilon instead.
 func SetDefaultEpsilon(ep float64) {
 	defaultEpsilon = ep
 }
 
-// Evaluate takes an expr and evaluates it using given args
-func Evaluate(expr Expr, args map[string]interface{}) (bool, error) {
-	return EvaluateWithArgResolver(expr, NewMapArgResolver(args))
+// ErrLimitExceeded is matched by the errors of evaluations of expressions
+// exceeding the limits set with WithLimits.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// EvalOption configures how expressions are evaluated.
+type EvalOption func(*evalConfig)
+
+// evalConfig holds the settings of an evaluation.
+type evalConfig struct {
+	functions *FunctionRegistry
+	clock     func() time.Time
+	missing   MissingVariablePolicy
+	defaults  map[string]interface{}
+	epsilon   float64
+	limits    Limits
+	coercion  Coercion
 }
 
-// EvaluateWithArgResolver takes an expr and evaluates it using given arg resolver
-func EvaluateWithArgResolver(expr Expr, args ArgResolver) (bool, error) {
-	if expr == nil {
-		return false, fmt.Errorf("provided expression is nil")
+// Limits bound the size of the expressions an evaluator accepts. Zero
+// fields do not limit anything.
+type Limits struct {
+	// MaxDepth is the maximum nesting of nodes, 1 for a single node
+	MaxDepth int
+	// MaxNodes is the maximum number of nodes
+	MaxNodes int
+}
+
+// MissingVariablePolicy decides how variables without argument are
+// evaluated.
+type MissingVariablePolicy int
+
+const (
+	// MissingVariableError fails the evaluation, which is the default.
+	MissingVariableError MissingVariablePolicy = iota
+	// MissingVariableNull evaluates the variable as NULL, which only
+	// equals NULL and makes ordering and pattern comparisons false.
+	MissingVariableNull
+	// MissingVariableFalse evaluates the comparison or logical operand
+	// the variable is part of as false.
+	MissingVariableFalse
+)
+
+// WithFunctions makes the functions of the registry callable from the
+// evaluated expression. They take precedence over the functions bound by
+// Parser.SetFunctionRegistry and the builtin ones.
+func WithFunctions(r *FunctionRegistry) EvalOption {
+	return func(c *evalConfig) {
+		c.functions = r
+	}
+}
+
+// WithClock sets the clock read by the builtin now() function, time.Now
+// by default. The clock is read at most once per evaluation, so every call
+// to now() within an expression returns the same time.
+func WithClock(clock func() time.Time) EvalOption {
+	return func(c *evalConfig) {
+		c.clock = clock
+	}
+}
+
+// WithMissingVariables sets the policy for variables without argument and
+// without default, see WithDefaults.
+func WithMissingVariables(policy MissingVariablePolicy) EvalOption {
+	return func(c *evalConfig) {
+		c.missing = policy
+	}
+}
+
+// WithDefaults sets the values of variables without argument. EXISTS is
+// not affected by the defaults.
+func WithDefaults(defaults map[string]interface{}) EvalOption {
+	return func(c *evalConfig) {
+		if c.defaults == nil {
+			c.defaults = make(map[string]interface{}, len(defaults))
+		}
+		for name, v := range defaults {
+			c.defaults[name] = v
+		}
+	}
+}
+
+// WithEpsilon sets the tolerance of number comparisons: two numbers are
+// equal if their relative difference is below epsilon. It defaults to
+// 1e-6.
+func WithEpsilon(epsilon float64) EvalOption {
+	return func(c *evalConfig) {
+		c.epsilon = epsilon
+	}
+}
+
+// WithLimits rejects the expressions exceeding the limits with an error
+// matching ErrLimitExceeded, before evaluating them.
+func WithLimits(limits Limits) EvalOption {
+	return func(c *evalConfig) {
+		c.limits = limits
+	}
+}
+
+// Evaluator evaluates expressions with the options it was created with.
+// It is safe for concurrent use, so evaluators with different options can
+// be used side by side.
+type Evaluator struct {
+	config *evalConfig
+}
+
+// NewEvaluator returns an evaluator configured by the options.
+func NewEvaluator(opts ...EvalOption) *Evaluator {
+	config := &evalConfig{epsilon: defaultEpsilon}
+	for _, opt := range opts {
+		opt(config)
 	}
+	return &Evaluator{config: config}
+}
+
+// Evaluate takes an expr and evaluates it using given args.
+func (e *Evaluator) Evaluate(expr Expr, args map[string]interface{}) (bool, error) {
+	return e.EvaluateWithArgResolver(expr, NewMapArgResolver(args))
+}
 
-	result, err := evaluateSubtree(expr, args)
+// EvaluateWithArgResolver takes an expr and evaluates it using given arg
+// resolver, see the package function of the same name.
+func (e *Evaluator) EvaluateWithArgResolver(expr Expr, args ArgResolver) (bool, error) {
+	ev, err := e.start(expr, args)
 	if err != nil {
 		return false, err
 	}
+	return ev.evaluate(expr)
+}
+
+// start returns the evaluation of expr, which must be within the limits.
+func (e *Evaluator) start(expr Expr, args ArgResolver) (*evaluation, error) {
+	if expr == nil {
+		return nil, fmt.Errorf("provided expression is nil")
+	}
+	if err := e.config.limits.check(expr); err != nil {
+		return nil, err
+	}
+	return &evaluation{evalConfig: e.config, args: args}, nil
+}
+
+// check returns an error if the expression exceeds the limits.
+func (l Limits) check(expr Expr) error {
+	if l.MaxDepth <= 0 && l.MaxNodes <= 0 {
+		return nil
+	}
+
+	size := &exprSize{limits: l}
+	Walk(sizeVisitor{size: size}, expr)
+
+	if l.MaxDepth > 0 && size.depth > l.MaxDepth {
+		return fmt.Errorf("expression deeper than %d nodes: %w", l.MaxDepth, ErrLimitExceeded)
+	} else if l.MaxNodes > 0 && size.nodes > l.MaxNodes {
+		return fmt.Errorf("expression of more than %d nodes: %w", l.MaxNodes, ErrLimitExceeded)
+	}
+	return nil
+}
+
+// exprSize is the size of an expression, measured until it exceeds the
+// limits.
+type exprSize struct {
+	limits       Limits
+	depth, nodes int
+}
+
+// sizeVisitor measures the nodes below a node at the given depth.
+type sizeVisitor struct {
+	size  *exprSize
+	depth int
+}
+
+func (v sizeVisitor) Visit(n Node) Visitor {
+	s, depth := v.size, v.depth+1
+	s.nodes++
+	if depth > s.depth {
+		s.depth = depth
+	}
+	if (s.limits.MaxDepth > 0 && s.depth > s.limits.MaxDepth) || (s.limits.MaxNodes > 0 && s.nodes > s.limits.MaxNodes) {
+		return nil
+	}
+	return sizeVisitor{size: s, depth: depth}
+}
+
+// evaluation holds the state of a single evaluation.
+type evaluation struct {
+	*evalConfig
+	args ArgResolver
+	// whether missing variables are UNKNOWN, see EvaluateTristate
+	tristate bool
+	// context of EvaluateContext, nil for evaluations without context
+	ctx context.Context
+	// time returned by now(), zero until read
+	nowTime time.Time
+	// variables resolved so far
+	vars []variable
+	// trace of Explain, nil for other evaluations
+	tracer *tracer
+}
+
+// variable is an argument resolved during an evaluation. Each variable is
+// resolved and converted to a literal at most once per evaluation.
+type variable struct {
+	name string
+	arg  interface{}
+	err  error
+	// whether the resolver found no argument, even if a default applies
+	missing bool
+	// literal of the argument, nil until converted
+	lit Expr
+}
+
+// now returns the time of the evaluation.
+func (ev *evaluation) now() time.Time {
+	if ev.nowTime.IsZero() {
+		clock := ev.clock
+		if clock == nil {
+			clock = time.Now
+		}
+		ev.nowTime = clock()
+	}
+	return ev.nowTime
+}
+
+// Evaluate takes an expr and evaluates it using given args
+func Evaluate(expr Expr, args map[string]interface{}, opts ...EvalOption) (bool, error) {
+	return NewEvaluator(opts...).Evaluate(expr, args)
+}
+
+// EvaluateWithArgResolver takes an expr and evaluates it using given arg resolver.
+// Operands are evaluated from left to right, resolving each variable when
+// it is first reached; a variable is resolved and converted at most once per
+// evaluation. AND, OR and NAND skip their right operand, including the
+// variables in it, once the left operand decides the result.
+func EvaluateWithArgResolver(expr Expr, args ArgResolver, opts ...EvalOption) (bool, error) {
+	return NewEvaluator(opts...).EvaluateWithArgResolver(expr, args)
+}
+
+// evaluate evaluates the expression to a boolean.
+func (ev *evaluation) evaluate(expr Expr) (bool, error) {
+	result, err := ev.evaluateSubtree(expr)
+	if ev.missingAsFalse(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
 	switch n := result.(type) {
 	case *BooleanLiteral:
 		return n.Val, nil
@@ -41,153 +278,564 @@ func EvaluateWithArgResolver(expr Expr, args ArgResolver) (bool, error) {
 }
 
 // evaluateSubtree performs given expr evaluation recursively
-func evaluateSubtree(expr Expr, args ArgResolver) (Expr, error) {
+func (ev *evaluation) evaluateSubtree(expr Expr) (Expr, error) {
+	if ev.tracer == nil {
+		return ev.evaluateNode(expr)
+	}
+	if p, ok := expr.(*ParenExpr); ok {
+		return ev.evaluateSubtree(p.Expr)
+	}
+	ev.tracer.push(expr)
+	v, err := ev.evaluateNode(expr)
+	ev.tracer.pop(v, err)
+	return v, err
+}
+
+// evaluateNode evaluates expr, evaluating its operands with evaluateSubtree.
+func (ev *evaluation) evaluateNode(expr Expr) (Expr, error) {
 	if expr == nil {
 		return falseExpr, fmt.Errorf("Provided expression is nil")
 	}
-
-	var (
-		err    error
-		lv, rv Expr
-	)
+	if err := ev.canceled(); err != nil {
+		return falseExpr, err
+	}
 
 	switch n := expr.(type) {
+	case *BadExpr:
+		return falseExpr, fmt.Errorf("cannot evaluate bad expression: %s", n.Err)
 	case *ParenExpr:
-		return evaluateSubtree(n.Expr, args)
+		return ev.evaluateSubtree(n.Expr)
 	case *BinaryExpr:
-		lv, err = evaluateSubtree(n.LHS, args)
+		return ev.evaluateBinary(n)
+	case *UnaryExpr:
+		if n.Op == EXISTS {
+			return ev.evaluateExists(n.Expr)
+		}
+		v, err := ev.evaluateSubtree(n.Expr)
+		if n.Op == NOT && ev.missingAsFalse(err) {
+			v, err = &BooleanLiteral{Val: false}, nil
+		}
 		if err != nil {
 			return falseExpr, err
 		}
-		rv, err = evaluateSubtree(n.RHS, args)
+		if isUnknown(v) {
+			return unknownExpr, nil
+		}
+		result, err := applyUnaryOperator(n.Op, v)
+		return result, withNode(err, n)
+	case *BetweenExpr:
+		result, err := ev.evaluateBetween(n)
+		if ev.missingAsFalse(err) {
+			return &BooleanLiteral{Val: false}, nil
+		}
+		return result, err
+	case *CallExpr:
+		return ev.evaluateCall(n)
+	case *VarRef:
+		return ev.literal(n.Val)
+	}
+
+	return expr, nil
+}
+
+// variable returns the named variable, resolving it on first use. The
+// argument is its default if the resolver does not find it.
+func (ev *evaluation) variable(name string) *variable {
+	for i := range ev.vars {
+		if ev.vars[i].name == name {
+			return &ev.vars[i]
+		}
+	}
+
+	v := variable{name: name}
+	v.arg, v.err = ev.resolve(name)
+	if errors.Is(v.err, ErrArgumentNotFound) {
+		v.missing = true
+		if d, ok := ev.defaults[name]; ok {
+			v.arg, v.err = d, nil
+		}
+	}
+	ev.vars = append(ev.vars, v)
+	return &ev.vars[len(ev.vars)-1]
+}
+
+// resolve resolves the argument of the named variable, passing the
+// context of the evaluation to resolvers supporting it.
+func (ev *evaluation) resolve(name string) (interface{}, error) {
+	if ev.ctx == nil {
+		return ev.args.Resolve(name)
+	}
+	if err := ev.canceled(); err != nil {
+		return nil, err
+	}
+
+	var (
+		arg interface{}
+		err error
+	)
+	if r, ok := ev.args.(ContextArgResolver); ok {
+		arg, err = r.ResolveContext(ev.ctx, name)
+	} else {
+		arg, err = ev.args.Resolve(name)
+	}
+	if err != nil {
+		// Resolvers fail with the error of the context once it is done.
+		if cerr := ev.canceled(); cerr != nil {
+			return nil, cerr
+		}
+	}
+	return arg, err
+}
+
+// literal returns the argument of the named variable as a literal.
+func (ev *evaluation) literal(name string) (Expr, error) {
+	v := ev.variable(name)
+	if v.err != nil {
+		return ev.unresolved(name, v.err)
+	}
+	if v.lit == nil {
+		lit, err := literalOf(name, v.arg)
 		if err != nil {
 			return falseExpr, err
 		}
-		return applyOperator(n.Op, lv, rv)
-	case *VarRef:
-		//index, err := strconv.Atoi(strings.Replace(n.Val, "$", "", -1))
-		index := n.Val
+		v.lit = lit
+	}
+	return v.lit, nil
+}
+
+// reset prepares the evaluation for reuse, keeping the storage of the
+// variables.
+func (ev *evaluation) reset(ctx context.Context, config *evalConfig, args ArgResolver) {
+	for i := range ev.vars {
+		ev.vars[i] = variable{}
+	}
+	*ev = evaluation{evalConfig: config, args: args, ctx: ctx, vars: ev.vars[:0]}
+}
+
+// unresolved returns the value of the named variable which could not be
+// resolved because of err, or the error of the evaluation.
+func (ev *evaluation) unresolved(name string, err error) (Expr, error) {
+	var canceled *CanceledError
+	if errors.As(err, &canceled) {
+		return falseExpr, err
+	} else if errors.Is(err, ErrArgumentNotFound) && ev.tristate {
+		return unknownExpr, nil
+	} else if errors.Is(err, ErrArgumentNotFound) && ev.missing == MissingVariableNull {
+		return &NullLiteral{}, nil
+	}
+	if errors.Is(err, ErrArgumentNotFound) {
+		return falseExpr, &VariableNotFoundError{Name: name, Err: err}
+	}
+	return falseExpr, &ResolveError{Name: name, Err: err}
+}
+
+// missingAsFalse reports whether err is caused by a missing variable which
+// the policy evaluates as false.
+func (ev *evaluation) missingAsFalse(err error) bool {
+	return ev.missing == MissingVariableFalse && errors.Is(err, ErrArgumentNotFound)
+}
+
+// evaluateBinary evaluates the operands of the binary expression from left
+// to right and applies its operator. AND, OR and NAND short-circuit: the
+// right operand is not evaluated, and none of its variables resolved, when
+// the left one is false for AND and NAND or true for OR.
+func (ev *evaluation) evaluateBinary(n *BinaryExpr) (Expr, error) {
+	var operands [2]Expr
+	for i, e := range []Expr{n.LHS, n.RHS} {
+		var (
+			v   Expr
+			err error
+		)
+		if i == 0 && (n.Op == IS || n.Op == ISNOT) {
+			// An absent variable is NULL rather than an error.
+			v, err = ev.evaluateOptional(e)
+		} else {
+			v, err = ev.evaluateSubtree(e)
+		}
+
+		if operandFalse, resultFalse := ev.missingOperand(n.Op, err); resultFalse {
+			return &BooleanLiteral{Val: false}, nil
+		} else if operandFalse {
+			v, err = &BooleanLiteral{Val: false}, nil
+		}
+		if err != nil {
+			return falseExpr, err
+		}
+		operands[i] = v
+
+		if b, ok := v.(*BooleanLiteral); ok && i == 0 {
+			// The left operand may decide the result on its own, in which
+			// case the right one is not evaluated.
+			if result, ok := shortCircuit(n.Op, b.Val); ok {
+				if ev.tracer != nil {
+					ev.tracer.skip(n.RHS)
+					ev.tracer.decide(0)
+				}
+				return &BooleanLiteral{Val: result}, nil
+			}
+		}
+	}
+	if ev.tracer != nil && (n.Op == AND || n.Op == OR || n.Op == NAND) {
+		ev.tracer.decide(1)
+	}
+	var (
+		result Expr
+		err    error
+	)
+	if isUnknown(operands[0]) || isUnknown(operands[1]) {
+		result, err = applyUnknown(n.Op, operands[0], operands[1])
+		err = withOperator(err, n.Op, operands[0], operands[1])
+	} else {
+		result, err = ev.applyOperator(n.Op, operands[0], operands[1])
+	}
+	return result, withNode(err, n)
+}
+
+// missingOperand tells how an operand of op failing with err is handled.
+// Under MissingVariableFalse a missing variable makes the operand of a
+// logical operator false and the result of a comparison false.
+func (ev *evaluation) missingOperand(op Token, err error) (operandFalse, resultFalse bool) {
+	if !ev.missingAsFalse(err) {
+		return false, false
+	}
+	switch op {
+	case AND, OR, XOR, NAND:
+		return true, false
+	}
+	return false, op.Precedence() == EQ.Precedence()
+}
+
+// shortCircuit returns the result of op decided by its left operand l
+// alone, if any.
+func shortCircuit(op Token, l bool) (result bool, ok bool) {
+	switch {
+	case op == AND && !l, op == OR && l:
+		return l, true
+	case op == NAND && !l:
+		return true, true
+	}
+	return false, false
+}
+
+// evaluateOptional evaluates expr like evaluateSubtree, except that a
+// variable without argument evaluates to NULL.
+func (ev *evaluation) evaluateOptional(expr Expr) (Expr, error) {
+	ref, ok := expr.(*VarRef)
+	if !ok {
+		return ev.evaluateSubtree(expr)
+	}
+
+	if ev.tracer != nil {
+		ev.tracer.push(ref)
+	}
+	result, err := ev.optionalVariable(ref)
+	if ev.tracer != nil {
+		ev.tracer.pop(result, err)
+	}
+	return result, err
+}
+
+// optionalVariable returns the argument of the variable as a literal, or
+// NULL if it has no argument.
+func (ev *evaluation) optionalVariable(ref *VarRef) (Expr, error) {
+	v := ev.variable(ref.Val)
+	if errors.Is(v.err, ErrArgumentNotFound) && ev.tristate {
+		return unknownExpr, nil
+	} else if errors.Is(v.err, ErrArgumentNotFound) {
+		return &NullLiteral{}, nil
+	} else if v.err != nil {
+		return ev.unresolved(ref.Val, v.err)
+	}
+	return ev.literal(ref.Val)
+}
+
+// evaluateExists checks whether the variable has an argument, which may
+// be nil.
+func (ev *evaluation) evaluateExists(expr Expr) (Expr, error) {
+	ref, ok := expr.(*VarRef)
+	if !ok {
+		return falseExpr, fmt.Errorf("EXISTS expects a variable, got %s", expr)
+	}
+	v := ev.variable(ref.Val)
+	if v.missing && ev.tristate {
+		return unknownExpr, nil
+	} else if v.missing {
+		return &BooleanLiteral{Val: false}, nil
+	} else if v.err != nil {
+		return ev.unresolved(ref.Val, v.err)
+	}
+	return &BooleanLiteral{Val: true}, nil
+}
+
+// evaluateBetween evaluates the operands of the range check and checks
+// that the value lies within the bounds, which are inclusive.
+func (ev *evaluation) evaluateBetween(n *BetweenExpr) (Expr, error) {
+	var v, lower, upper Expr
+	for _, e := range []struct {
+		dst  *Expr
+		expr Expr
+	}{{&v, n.Expr}, {&lower, n.Lower}, {&upper, n.Upper}} {
+		r, err := ev.evaluateSubtree(e.expr)
 		if err != nil {
-			return falseExpr, fmt.Errorf("Failed to resolve argument index %s: %s", n.Val, err.Error())
+			return falseExpr, err
+		}
+		if isUnknown(r) {
+			return unknownExpr, nil
 		}
+		*e.dst = r
+	}
+
+	result, err := ev.applyBetween(n.Op, v, lower, upper)
+	if err != nil {
+		return falseExpr, withNode(err, n)
+	}
+	return result, nil
+}
 
-		arg, err := args.Resolve(index)
+// applyBetween checks the range like applyBETWEEN, comparing numbers with
+// the epsilon of the configuration
+func (c *evalConfig) applyBetween(op Token, v, lower, upper Expr) (*BooleanLiteral, error) {
+	if isNull(v) || isNull(lower) || isNull(upper) {
+		// Ranges of NULL do not hold, as in SQL.
+		return &BooleanLiteral{Val: false}, nil
+	}
+	if c.coercion == CoercionStrings {
+		v, lower = coercePair(v, lower)
+		v, upper = coercePair(v, upper)
+	}
+	return applyBETWEEN(op, v, lower, upper, c.epsilon)
+}
+
+// applyBETWEEN checks that v lies within the inclusive bounds, or not for
+// NOTBETWEEN
+func applyBETWEEN(op Token, v, lower, upper Expr, eps float64) (*BooleanLiteral, error) {
+	result, err := applyGTE(v, lower, eps)
+	if err != nil {
+		return nil, withOperator(err, op, v, lower)
+	}
+	if result.Val {
+		if result, err = applyLTE(v, upper, eps); err != nil {
+			return nil, withOperator(err, op, v, upper)
+		}
+	}
+	if op == NOTBETWEEN {
+		result.Val = !result.Val
+	}
+	return result, nil
+}
+
+// evaluateCall evaluates the arguments of the call and calls the function.
+func (ev *evaluation) evaluateCall(n *CallExpr) (Expr, error) {
+	fn, ok := n.fn, n.fn != nil
+	if ev.functions != nil {
+		if f, found := ev.functions.Lookup(n.Name); found {
+			fn, ok = f, true
+		}
+	}
+	if !ok {
+		fn, ok = builtinFunctions.Lookup(n.Name)
+	}
+	if !ok {
+		return falseExpr, fmt.Errorf("unknown function %s", n.Name)
+	}
+	if fn.now {
+		if err := fn.CheckArity(len(n.Arguments)); err != nil {
+			return falseExpr, err
+		}
+		return &TimeLiteral{Val: ev.now()}, nil
+	}
 
+	args := make([]Expr, len(n.Arguments))
+	for i, arg := range n.Arguments {
+		v, err := ev.evaluateSubtree(arg)
 		if err != nil {
-			return falseExpr, fmt.Errorf("argument %v not resolved: %s", index, err.Error())
-		}
-
-		typeof := reflect.TypeOf(arg)
-		if typeof == nil {
-			return falseExpr, fmt.Errorf("Unsupported argument nil type")
-		}
-
-		kind := typeof.Kind()
-		switch kind {
-		case reflect.Int:
-			return &NumberLiteral{Val: float64(arg.(int))}, nil
-		case reflect.Int32:
-			return &NumberLiteral{Val: float64(arg.(int32))}, nil
-		case reflect.Int64:
-			return &NumberLiteral{Val: float64(arg.(int64))}, nil
-		case reflect.Float32:
-			return &NumberLiteral{Val: float64(arg.(float32))}, nil
-		case reflect.Float64:
-			return &NumberLiteral{Val: float64(arg.(float64))}, nil
-		case reflect.String:
-			if num, ok := arg.(json.Number); ok {
-				f, err := num.Float64()
+			return falseExpr, err
+		}
+		if isUnknown(v) {
+			return unknownExpr, nil
+		}
+		args[i] = v
+	}
+
+	return fn.Call(args)
+}
+
+// literalOf converts the value of the named argument to a literal.
+func literalOf(name string, arg interface{}) (Expr, error) {
+	switch v := arg.(type) {
+	case time.Time:
+		return &TimeLiteral{Val: v}, nil
+	case time.Duration:
+		return &DurationLiteral{Val: v}, nil
+	case NumberCollection:
+		return &NumberCollectionLiteral{Val: v}, nil
+	case StringCollection:
+		return &StringCollectionLiteral{Val: v}, nil
+	}
+
+	typeof := reflect.TypeOf(arg)
+	if typeof == nil {
+		return &NullLiteral{}, nil
+	}
+
+	// Named types are converted by their kind, e.g. type Score int as a
+	// number.
+	rv := reflect.ValueOf(arg)
+	kind := typeof.Kind()
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		n := numberOf(rv)
+		return &n, nil
+	case reflect.String:
+		if num, ok := arg.(json.Number); ok {
+			n, err := parseNumber(string(num))
+			if err != nil {
+				return falseExpr, fmt.Errorf("Unsupported JSON Number %v type: %s", arg, kind)
+			}
+			return &n, nil
+		}
+		return &StringLiteral{Val: rv.String()}, nil
+	case reflect.Bool:
+		return &BooleanLiteral{Val: rv.Bool()}, nil
+	case reflect.Slice:
+		// Slices of strings and numbers are looked up in place rather
+		// than copied.
+		switch arg.(type) {
+		case []string:
+			return &SliceStringLiteral{Val: arg.([]string)}, nil
+		case []int:
+			return &NumberCollectionLiteral{Val: intSlice(arg.([]int))}, nil
+		case []int32:
+			return &NumberCollectionLiteral{Val: int32Slice(arg.([]int32))}, nil
+		case []int64:
+			return &NumberCollectionLiteral{Val: int64Slice(arg.([]int64))}, nil
+		case []float32:
+			return &NumberCollectionLiteral{Val: float32Slice(arg.([]float32))}, nil
+		case []float64:
+			return &SliceNumberLiteral{Val: arg.([]float64)}, nil
+		case []json.Number:
+			items := arg.([]json.Number)
+			nums := make([]NumberLiteral, len(items))
+			for i, v := range items {
+				n, err := parseNumber(string(v))
 				if err != nil {
-					return falseExpr, fmt.Errorf("Unsupported JSON Number %v type: %s", arg, kind)
+					return falseExpr, fmt.Errorf("Unsupported JSON Number %v in argument %s", v, name)
 				}
-				return &NumberLiteral{Val: f}, nil
+				nums[i] = n
 			}
-			return &StringLiteral{Val: arg.(string)}, nil
-		case reflect.Bool:
-			return &BooleanLiteral{Val: arg.(bool)}, nil
-		case reflect.Slice:
-			switch arg.(type) {
-			case []string:
-				ssl := NewSliceStringLiteral(arg.([]string))
-				return ssl, nil
-			case []int:
-				snl := &SliceNumberLiteral{}
-				for _, v := range arg.([]int) {
-					snl.Val = append(snl.Val, float64(v))
-				}
-				return snl, nil
-			case []int32:
-				snl := &SliceNumberLiteral{}
-				for _, v := range arg.([]int32) {
-					snl.Val = append(snl.Val, float64(v))
-				}
-				return snl, nil
-			case []int64:
-				snl := &SliceNumberLiteral{}
-				for _, v := range arg.([]int64) {
-					snl.Val = append(snl.Val, float64(v))
-				}
-				return snl, nil
-			case []float32:
-				snl := &SliceNumberLiteral{}
-				for _, v := range arg.([]float32) {
-					snl.Val = append(snl.Val, float64(v))
-				}
-				return snl, nil
-			case []float64:
-				snl := &SliceNumberLiteral{}
-				for _, v := range arg.([]float64) {
-					snl.Val = append(snl.Val, float64(v))
-				}
-				return snl, nil
-			case []json.Number:
-				snl := &SliceNumberLiteral{}
-				for _, v := range arg.([]json.Number) {
-					f, _ := v.Float64()
-					snl.Val = append(snl.Val, f)
-				}
-				return snl, nil
-			case []interface{}:
-				items := arg.([]interface{})
-				if len(items) != 0 {
-					item := items[0]
-					switch item.(type) {
-					case string:
-						val := []string{}
-						for _, v := range items {
-							val = append(val, v.(string))
-						}
-						ssl := NewSliceStringLiteral(val)
-						return ssl, nil
-					case float64:
-						snl := &SliceNumberLiteral{}
-						for _, v := range items {
-							snl.Val = append(snl.Val, v.(float64))
-						}
-						return snl, nil
-					case json.Number:
-						snl := &SliceNumberLiteral{}
-						for _, v := range items {
-							f, _ := v.(json.Number).Float64()
-							snl.Val = append(snl.Val, f)
-						}
-						return snl, nil
-					}
-				}
+			return newSliceNumberLiteral(nums), nil
+		case []interface{}:
+			if lit, err := interfacesLiteral(name, arg.([]interface{})); lit != nil || err != nil {
+				return lit, err
 			}
-		case reflect.Struct:
-			return createCollectionLiteral(n.Val, kind, arg)
-		case reflect.Ptr:
-			return createCollectionLiteral(n.Val, kind, arg)
+			return falseExpr, &UnsupportedArgumentError{Name: name, GoType: typeof}
 		}
+		if lit := sliceLiteral(rv); lit != nil {
+			return lit, nil
+		}
+	case reflect.Struct:
+		return createCollectionLiteral(name, arg)
+	case reflect.Ptr:
+		return createCollectionLiteral(name, arg)
+	}
+
+	return falseExpr, &UnsupportedArgumentError{Name: name, GoType: typeof}
+}
 
-		return falseExpr, fmt.Errorf("Unsupported argument %s type: %s", n.Val, kind)
+// numberOf converts a value of any integer or float kind to a number,
+// keeping integers exact.
+func numberOf(rv reflect.Value) NumberLiteral {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return intNumber(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uintNumber(rv.Uint())
 	}
+	return NumberLiteral{Val: rv.Float()}
+}
 
-	return expr, nil
+// interfacesLiteral converts the items of a []interface{}, which are all
+// strings or all numbers of any kind, and returns nil for other items. It
+// fails on malformed json.Number items.
+func interfacesLiteral(name string, items []interface{}) (Expr, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	if _, ok := items[0].(json.Number); !ok && reflect.ValueOf(items[0]).Kind() == reflect.String {
+		val := make([]string, len(items))
+		for i, item := range items {
+			rv := reflect.ValueOf(item)
+			if _, ok := item.(json.Number); ok || rv.Kind() != reflect.String {
+				return nil, nil
+			}
+			val[i] = rv.String()
+		}
+		return &SliceStringLiteral{Val: val}, nil
+	}
+
+	nums := make([]NumberLiteral, len(items))
+	for i, item := range items {
+		if num, ok := item.(json.Number); ok {
+			n, err := parseNumber(string(num))
+			if err != nil {
+				return falseExpr, fmt.Errorf("Unsupported JSON Number %v in argument %s", num, name)
+			}
+			nums[i] = n
+			continue
+		}
+		rv := reflect.ValueOf(item)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			nums[i] = numberOf(rv)
+		default:
+			return nil, nil
+		}
+	}
+	return newSliceNumberLiteral(nums), nil
+}
+
+// sliceLiteral converts slices of named string types and of numbers of
+// any kind, and returns nil for other slices. Slices of strings or
+// float64 of a named slice type are used without copying.
+func sliceLiteral(rv reflect.Value) Expr {
+	elem := rv.Type().Elem()
+	switch elem.Kind() {
+	case reflect.String:
+		if elem == stringType {
+			return &SliceStringLiteral{Val: rv.Convert(stringsType).Interface().([]string)}
+		}
+		val := make([]string, rv.Len())
+		for i := range val {
+			val[i] = rv.Index(i).String()
+		}
+		return &SliceStringLiteral{Val: val}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if elem == float64Type {
+			return &SliceNumberLiteral{Val: rv.Convert(float64sType).Interface().([]float64)}
+		}
+		nums := make([]NumberLiteral, rv.Len())
+		for i := range nums {
+			nums[i] = numberOf(rv.Index(i))
+		}
+		return newSliceNumberLiteral(nums)
+	}
+	return nil
 }
 
-func createCollectionLiteral(argName string, itemType reflect.Kind, arg interface{}) (Expr, error) {
+var (
+	stringType   = reflect.TypeOf("")
+	stringsType  = reflect.TypeOf([]string(nil))
+	float64Type  = reflect.TypeOf(float64(0))
+	float64sType = reflect.TypeOf([]float64(nil))
+)
+
+func createCollectionLiteral(argName string, arg interface{}) (Expr, error) {
 	numCollection := tryCreateNumberCollectionLiteral(arg)
 
 	if numCollection != nil {
@@ -200,7 +848,7 @@ func createCollectionLiteral(argName string, itemType reflect.Kind, arg interfac
 		return strCollection, nil
 	}
 
-	return falseExpr, fmt.Errorf("unsupported structure of argument %s type: %s", argName, itemType)
+	return falseExpr, &UnsupportedArgumentError{Name: argName, GoType: reflect.TypeOf(arg)}
 }
 
 func tryCreateNumberCollectionLiteral(arg interface{}) *NumberCollectionLiteral {
@@ -235,79 +883,463 @@ func tryCreateStringCollectionLiteral(arg interface{}) *StringCollectionLiteral
 	return nil
 }
 
-// applyOperator is a dispatcher of the evaluation according to operator
-func applyOperator(op Token, l, r Expr) (*BooleanLiteral, error) {
+// applyOperator applies the binary operator to l/r operands, comparing
+// numbers with the epsilon of the configuration
+func (c *evalConfig) applyOperator(op Token, l, r Expr) (Expr, error) {
+	if comparesNullAsFalse(op) && (isNull(l) || isNull(r)) {
+		return &BooleanLiteral{Val: false}, nil
+	}
+	if c.coercion == CoercionStrings {
+		l, r = coerce(op, l, r)
+	}
+	result, err := dispatchOperator(op, l, r, c.epsilon)
+	if err != nil {
+		return result, withOperator(err, op, l, r)
+	}
+	return result, nil
+}
+
+// dispatchOperator is a dispatcher of the evaluation according to operator
+func dispatchOperator(op Token, l, r Expr, eps float64) (Expr, error) {
 	switch op {
 	case AND:
 		return applyAND(l, r)
 	case OR:
 		return applyOR(l, r)
 	case EQ:
-		return applyEQ(l, r)
+		return applyEQ(l, r, eps)
 	case NEQ:
-		return applyNQ(l, r)
+		return applyNQ(l, r, eps)
+	case IS:
+		return &BooleanLiteral{Val: isNull(l)}, nil
+	case ISNOT:
+		return &BooleanLiteral{Val: !isNull(l)}, nil
+	case IEQ:
+		return applyIEQ(l, r, eps)
+	case INEQ:
+		return negate(applyIEQ(l, r, eps))
 	case GT:
 		return applyGT(l, r)
 	case GTE:
-		return applyGTE(l, r)
+		return applyGTE(l, r, eps)
 	case LT:
 		return applyLT(l, r)
 	case LTE:
-		return applyLTE(l, r)
+		return applyLTE(l, r, eps)
 	case XOR:
 		return applyXOR(l, r)
 	case NAND:
 		return applyNAND(l, r)
 	case IN:
-		return applyIN(l, r)
+		return applyIN(l, r, eps)
 	case NOTIN:
-		return applyNOTIN(l, r)
+		return applyNOTIN(l, r, eps)
+	case IIN:
+		return applyIIN(l, r, eps)
+	case NOTIIN:
+		return negate(applyIIN(l, r, eps))
 	case EREG:
 		return applyEREG(l, r)
 	case NEREG:
 		return applyNEREG(l, r)
 	case CONTAINS:
-		return applyCONTAINS(l, r)
+		return applyCONTAINS(l, r, eps)
 	case NOTCONTAINS:
-		return applyNOTCONTAINS(l, r)
+		return applyNOTCONTAINS(l, r, eps)
+	case STARTSWITH:
+		return applySTARTSWITH(l, r)
+	case NOTSTARTSWITH:
+		return negate(applySTARTSWITH(l, r))
+	case ENDSWITH:
+		return applyENDSWITH(l, r)
+	case NOTENDSWITH:
+		return negate(applyENDSWITH(l, r))
+	case LIKE:
+		return applyLIKE(l, r)
+	case NOTLIKE:
+		return negate(applyLIKE(l, r))
+	case ILIKE:
+		return applyILIKE(l, r)
+	case NOTILIKE:
+		return negate(applyILIKE(l, r))
+	case MATCHES:
+		return applyMATCHES(l, r)
+	case NOTMATCHES:
+		return negate(applyMATCHES(l, r))
+	case ADD:
+		return applyADD(l, r)
+	case SUB:
+		return applySUB(l, r)
+	case MUL:
+		return applyMUL(l, r)
+	case DIV:
+		return applyDIV(l, r)
+	case MOD:
+		return applyMOD(l, r)
 	}
 	return &BooleanLiteral{Val: false}, fmt.Errorf("Unsupported operator: %s", op)
 }
 
+// applyUnaryOperator is a dispatcher of the evaluation according to unary operator
+func applyUnaryOperator(op Token, v Expr) (Expr, error) {
+	var (
+		result Expr
+		err    error
+	)
+	switch op {
+	case NOT:
+		result, err = applyNOT(v)
+	case SUB:
+		result, err = applyNEG(v)
+	default:
+		return falseExpr, fmt.Errorf("Unsupported unary operator: %s", op)
+	}
+	if err != nil {
+		return result, withUnaryOperator(err, op, v)
+	}
+	return result, nil
+}
+
+// applyNOT applies NOT operation to the operand
+func applyNOT(v Expr) (*BooleanLiteral, error) {
+	a, err := getBoolean(v)
+	if err != nil {
+		return nil, err
+	}
+	return &BooleanLiteral{Val: !a}, nil
+}
+
+// applyNEG applies unary - operation to the operand
+func applyNEG(v Expr) (Expr, error) {
+	if d, ok := v.(*DurationLiteral); ok {
+		return &DurationLiteral{Val: -d.Val}, nil
+	}
+	a, err := getNumberLiteral(v)
+	if err != nil {
+		return nil, err
+	}
+	n := negateNumber(a)
+	return &n, nil
+}
+
+// applyADD applies + operation to l/r operands
+func applyADD(l, r Expr) (Expr, error) {
+	switch a := l.(type) {
+	case *TimeLiteral:
+		if b, ok := r.(*DurationLiteral); ok {
+			return &TimeLiteral{Val: a.Val.Add(b.Val)}, nil
+		}
+		return nil, typeMismatch(l, r)
+	case *DurationLiteral:
+		switch b := r.(type) {
+		case *TimeLiteral:
+			return &TimeLiteral{Val: b.Val.Add(a.Val)}, nil
+		case *DurationLiteral:
+			return &DurationLiteral{Val: a.Val + b.Val}, nil
+		}
+		return nil, typeMismatch(l, r)
+	}
+
+	a, b, err := getNumbers(l, r)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := intArithmetic(ADD, l.(*NumberLiteral), r.(*NumberLiteral)); ok {
+		return &n, nil
+	}
+	return &NumberLiteral{Val: a + b}, nil
+}
+
+// applySUB applies - operation to l/r operands
+func applySUB(l, r Expr) (Expr, error) {
+	switch a := l.(type) {
+	case *TimeLiteral:
+		switch b := r.(type) {
+		case *TimeLiteral:
+			return &DurationLiteral{Val: a.Val.Sub(b.Val)}, nil
+		case *DurationLiteral:
+			return &TimeLiteral{Val: a.Val.Add(-b.Val)}, nil
+		}
+		return nil, typeMismatch(l, r)
+	case *DurationLiteral:
+		if b, ok := r.(*DurationLiteral); ok {
+			return &DurationLiteral{Val: a.Val - b.Val}, nil
+		}
+		return nil, typeMismatch(l, r)
+	}
+
+	a, b, err := getNumbers(l, r)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := intArithmetic(SUB, l.(*NumberLiteral), r.(*NumberLiteral)); ok {
+		return &n, nil
+	}
+	return &NumberLiteral{Val: a - b}, nil
+}
+
+// applyMUL applies * operation to l/r operands
+func applyMUL(l, r Expr) (Expr, error) {
+	// Durations can be scaled by a number.
+	if _, ok := r.(*DurationLiteral); ok {
+		l, r = r, l
+	}
+	if d, ok := l.(*DurationLiteral); ok {
+		b, err := getNumber(r)
+		if err != nil {
+			return nil, err
+		}
+		return &DurationLiteral{Val: time.Duration(float64(d.Val) * b)}, nil
+	}
+
+	a, b, err := getNumbers(l, r)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := intArithmetic(MUL, l.(*NumberLiteral), r.(*NumberLiteral)); ok {
+		return &n, nil
+	}
+	return &NumberLiteral{Val: a * b}, nil
+}
+
+// applyDIV applies / operation to l/r operands
+func applyDIV(l, r Expr) (Expr, error) {
+	if d, ok := l.(*DurationLiteral); ok {
+		b, err := getNumber(r)
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero: %v / %v", l, r)
+		}
+		return &DurationLiteral{Val: time.Duration(float64(d.Val) / b)}, nil
+	}
+
+	a, b, err := getNumbers(l, r)
+	if err != nil {
+		return nil, err
+	}
+	if b == 0 {
+		return nil, fmt.Errorf("division by zero: %v / %v", l, r)
+	}
+	return &NumberLiteral{Val: a / b}, nil
+}
+
+// applyMOD applies % operation to l/r operands
+func applyMOD(l, r Expr) (*NumberLiteral, error) {
+	a, b, err := getNumbers(l, r)
+	if err != nil {
+		return nil, err
+	}
+	if b == 0 {
+		return nil, fmt.Errorf("division by zero: %v %% %v", l, r)
+	}
+	if n, ok := intArithmetic(MOD, l.(*NumberLiteral), r.(*NumberLiteral)); ok {
+		return &n, nil
+	}
+	return &NumberLiteral{Val: math.Mod(a, b)}, nil
+}
+
 // applyEREG applies EREG operation to l/r operands
 func applyNEREG(l, r Expr) (*BooleanLiteral, error) {
-	result, err := applyEREG(l, r)
-	result.Val = !result.Val
-	return result, err
+	return negate(applyEREG(l, r))
 }
 
 // applyEREG applies EREG operation to l/r operands
 func applyEREG(l, r Expr) (*BooleanLiteral, error) {
-	var (
-		a     string
-		b     string
-		err   error
-		match bool
-	)
-	a, err = getString(l)
+	a, err := getString(l)
+	if err != nil {
+		return nil, err
+	}
+	re, err := getRegexp(r)
 	if err != nil {
 		return nil, err
 	}
+	return &BooleanLiteral{Val: re.MatchString(a)}, nil
+}
+
+// negate inverts the result of a boolean operation unless it failed
+func negate(result *BooleanLiteral, err error) (*BooleanLiteral, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &BooleanLiteral{Val: !result.Val}, nil
+}
+
+// getStrings returns the values of two string operands
+func getStrings(l, r Expr) (string, string, error) {
+	a, err := getString(l)
+	if err != nil {
+		return "", "", err
+	}
+	b, err := getString(r)
+	if err != nil {
+		return "", "", err
+	}
+	return a, b, nil
+}
+
+// applySTARTSWITH applies STARTS WITH operation to l/r operands
+func applySTARTSWITH(l, r Expr) (*BooleanLiteral, error) {
+	a, b, err := getStrings(l, r)
+	if err != nil {
+		return nil, err
+	}
+	return &BooleanLiteral{Val: strings.HasPrefix(a, b)}, nil
+}
+
+// applyENDSWITH applies ENDS WITH operation to l/r operands
+func applyENDSWITH(l, r Expr) (*BooleanLiteral, error) {
+	a, b, err := getStrings(l, r)
+	if err != nil {
+		return nil, err
+	}
+	return &BooleanLiteral{Val: strings.HasSuffix(a, b)}, nil
+}
+
+// applyLIKE applies LIKE operation to l/r operands, where r is a SQL
+// pattern in which % matches any sequence of characters and _ matches a
+// single character
+func applyLIKE(l, r Expr) (*BooleanLiteral, error) {
+	return matchPattern(LIKE, l, r)
+}
+
+// applyILIKE applies ILIKE operation to l/r operands, which is LIKE
+// ignoring case
+func applyILIKE(l, r Expr) (*BooleanLiteral, error) {
+	return matchPattern(ILIKE, l, r)
+}
+
+// applyMATCHES applies MATCHES operation to l/r operands, where r is a glob
+// pattern in which * matches any sequence of characters, ? matches a single
+// character and [...] matches a character class
+func applyMATCHES(l, r Expr) (*BooleanLiteral, error) {
+	return matchPattern(MATCHES, l, r)
+}
 
-	b, err = getString(r)
+// matchPattern matches the whole of l against the pattern r of op, which
+// is compiled at parse time unless it is only known at evaluation
+func matchPattern(op Token, l, r Expr) (*BooleanLiteral, error) {
+	a, err := getString(l)
+	if err != nil {
+		return nil, err
+	}
+	if re, ok := r.(*RegexLiteral); ok {
+		return &BooleanLiteral{Val: re.Val.MatchString(a)}, nil
+	}
+	b, err := getString(r)
 	if err != nil {
 		return nil, err
 	}
-	match = false
-	match, err = regexp.MatchString(b, a)
+	re, err := regexCache.compile(patternToRegexp(op, b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", b, err)
+	}
+	return &BooleanLiteral{Val: re.MatchString(a)}, nil
+}
 
-	// pp.Print(a, b, match)
-	return &BooleanLiteral{Val: match}, err
+// isPatternOperator returns true for the LIKE, ILIKE and MATCHES
+// operators and their negations.
+func isPatternOperator(op Token) bool {
+	switch op {
+	case LIKE, NOTLIKE, ILIKE, NOTILIKE, MATCHES, NOTMATCHES:
+		return true
+	}
+	return false
+}
+
+// patternToRegexp translates the pattern of a LIKE, ILIKE or MATCHES
+// operation into an anchored regular expression.
+func patternToRegexp(op Token, pattern string) string {
+	switch op {
+	case ILIKE, NOTILIKE:
+		return "(?i)" + likeToRegexp(pattern)
+	case MATCHES, NOTMATCHES:
+		return globToRegexp(pattern)
+	}
+	return likeToRegexp(pattern)
+}
+
+// likeToRegexp translates a LIKE pattern into an anchored regular
+// expression. A backslash makes the following character match literally.
+func likeToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if escaped {
+		b.WriteString(`\\`)
+	}
+	b.WriteString(")$")
+	return b.String()
+}
+
+// globToRegexp translates a glob pattern into an anchored regular
+// expression. A backslash makes the following character match literally
+// and [!...] negates a character class.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				c = runes[i]
+			}
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := i + 1
+			if end < len(runes) && runes[end] == '!' {
+				end++
+			}
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				// An unterminated class matches a literal [.
+				b.WriteString(`\[`)
+				break
+			}
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i = end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(")$")
+	return b.String()
 }
 
 // applyNOTIN applies NOT IN operation to l/r operands
-func applyNOTIN(l, r Expr) (*BooleanLiteral, error) {
-	result, err := applyIN(l, r)
+func applyNOTIN(l, r Expr, eps float64) (*BooleanLiteral, error) {
+	result, err := applyIN(l, r, eps)
 	if err != nil {
 		return nil, err
 	}
@@ -316,78 +1348,100 @@ func applyNOTIN(l, r Expr) (*BooleanLiteral, error) {
 }
 
 // applyIN applies IN operation to l/r operands
-func applyIN(l, r Expr) (*BooleanLiteral, error) {
+func applyIN(l, r Expr, eps float64) (*BooleanLiteral, error) {
 	var (
 		err   error
 		found bool
 	)
 	// pp.Print(l)
-	switch t := l.(type) {
+	switch l.(type) {
 	case *StringLiteral:
 		var a string
-		var b map[string]struct{}
-
 		a, err = getString(l)
 		if err != nil {
 			return nil, err
 		}
 
-		switch r.(type) {
+		switch c := r.(type) {
 		case *StringCollectionLiteral:
-			rNumbers := r.(*StringCollectionLiteral)
-
-			return &BooleanLiteral{Val: rNumbers.Val.Has(a)}, nil
-		}
-
-		b, err = getMapString(r)
-		if err != nil {
-			return nil, err
+			found = c.Val.Has(a)
+		case *SliceStringLiteral:
+			found = c.has(a)
+		default:
+			return nil, typeMismatch(l, r)
 		}
-		_, found = b[a]
 	case *NumberLiteral:
-		var a float64
-		var b []float64
-		a, err = getNumber(l)
-		if err != nil {
-			return nil, err
-		}
+		a := l.(*NumberLiteral)
 
-		switch r.(type) {
+		switch c := r.(type) {
 		case *NumberCollectionLiteral:
-			rNumbers := r.(*NumberCollectionLiteral)
-
-			return &BooleanLiteral{Val: rNumbers.Val.Has(a)}, nil
+			found = hasNumber(c.Val, a, eps)
+		case *SliceNumberLiteral:
+			found = c.has(a, eps)
+		default:
+			return nil, typeMismatch(l, r)
 		}
+	default:
+		return nil, typeMismatch(l, r)
+	}
 
-		b, err = getSliceNumber(r)
+	return &BooleanLiteral{Val: found}, nil
+}
 
-		if err != nil {
-			return nil, err
-		}
+// applyIEQ applies =* operation to l/r operands, which compares strings
+// under simple Unicode case folding and other literals like ==. Simple
+// folding maps each character to a single one, so "ß" does not equal "SS"
+func applyIEQ(l, r Expr, eps float64) (*BooleanLiteral, error) {
+	a, err := getString(l)
+	if err != nil {
+		return applyEQ(l, r, eps)
+	}
+	b, err := getString(r)
+	if err != nil {
+		return falseExpr, typeMismatch(l, r)
+	}
+	return &BooleanLiteral{Val: strings.EqualFold(a, b)}, nil
+}
 
-		found = false
-		for _, e := range b {
-			if float64Equal(a, e, defaultEpsilon) {
-				found = true
+// applyIIN applies IIN operation to l/r operands, which looks up strings
+// under simple Unicode case folding like =* and other literals like IN
+func applyIIN(l, r Expr, eps float64) (*BooleanLiteral, error) {
+	a, err := getString(l)
+	if err != nil {
+		return applyIN(l, r, eps)
+	}
 
-				break
+	switch n := r.(type) {
+	case *SliceStringLiteral:
+		for _, item := range n.Val {
+			if strings.EqualFold(a, item) {
+				return &BooleanLiteral{Val: true}, nil
+			}
+		}
+	case *StringCollectionLiteral:
+		c, ok := n.Val.(*MapStringCollection)
+		if !ok {
+			return nil, fmt.Errorf("collection %T does not support case-insensitive lookup", n.Val)
+		}
+		for item := range c.items {
+			if strings.EqualFold(a, item) {
+				return &BooleanLiteral{Val: true}, nil
 			}
 		}
 	default:
-		return nil, fmt.Errorf("Can not evaluate Literal of unknow type %s %T", t, t)
+		return nil, typeMismatch(l, r)
 	}
-
-	return &BooleanLiteral{Val: found}, nil
+	return &BooleanLiteral{Val: false}, nil
 }
 
 // applyCONTAINS applies CONTAINS operation to l/r operands
-func applyCONTAINS(l, r Expr) (*BooleanLiteral, error) {
-	return applyIN(r, l)
+func applyCONTAINS(l, r Expr, eps float64) (*BooleanLiteral, error) {
+	return applyIN(r, l, eps)
 }
 
 // applyNOTCONTAINS applies NOT CONTAINS operation to l/r operands
-func applyNOTCONTAINS(l, r Expr) (*BooleanLiteral, error) {
-	result, err := applyCONTAINS(l, r)
+func applyNOTCONTAINS(l, r Expr, eps float64) (*BooleanLiteral, error) {
+	result, err := applyCONTAINS(l, r, eps)
 	if err != nil {
 		return nil, err
 	}
@@ -464,10 +1518,14 @@ func applyOR(l, r Expr) (*BooleanLiteral, error) {
 }
 
 // applyEQ applies == operation to l/r operands
-func applyEQ(l, r Expr) (*BooleanLiteral, error) {
+func applyEQ(l, r Expr, eps float64) (*BooleanLiteral, error) {
+	if isNull(l) || isNull(r) {
+		return &BooleanLiteral{Val: isNull(l) && isNull(r)}, nil
+	}
+
 	var (
 		as, bs string
-		an, bn float64
+		an, bn *NumberLiteral
 		ab, bb bool
 		err    error
 	)
@@ -475,32 +1533,39 @@ func applyEQ(l, r Expr) (*BooleanLiteral, error) {
 	if err == nil {
 		bs, err = getString(r)
 		if err != nil {
-			return falseExpr, fmt.Errorf("cannot compare string(%v) with non-string(%v)", l, r)
+			return falseExpr, typeMismatch(l, r)
 		}
 		return &BooleanLiteral{Val: (as == bs)}, nil
 	}
-	an, err = getNumber(l)
+	an, err = getNumberLiteral(l)
 	if err == nil {
-		bn, err = getNumber(r)
+		bn, err = getNumberLiteral(r)
 		if err != nil {
-			return falseExpr, fmt.Errorf("Cannot compare number with non-number")
+			return falseExpr, typeMismatch(l, r)
 		}
-		return &BooleanLiteral{Val: float64Equal(an, bn, defaultEpsilon)}, nil
+		return &BooleanLiteral{Val: numberEqual(an, bn, eps)}, nil
 	}
 	ab, err = getBoolean(l)
 	if err == nil {
 		bb, err = getBoolean(r)
 		if err != nil {
-			return falseExpr, fmt.Errorf("Cannot compare boolean with non-boolean")
+			return falseExpr, typeMismatch(l, r)
 		}
 		return &BooleanLiteral{Val: (ab == bb)}, nil
 	}
-	return falseExpr, nil
+	if isOrdered(l) {
+		c, err := compareOrdered(l, r)
+		if err != nil {
+			return falseExpr, err
+		}
+		return &BooleanLiteral{Val: c == 0}, nil
+	}
+	return &BooleanLiteral{Val: false}, nil
 }
 
 // applyNQ applies != operation to l/r operands
-func applyNQ(l, r Expr) (*BooleanLiteral, error) {
-	result, err := applyEQ(l, r)
+func applyNQ(l, r Expr, eps float64) (*BooleanLiteral, error) {
+	result, err := applyEQ(l, r, eps)
 	if err != nil {
 		return nil, err
 	}
@@ -510,70 +1575,134 @@ func applyNQ(l, r Expr) (*BooleanLiteral, error) {
 
 // applyGT applies > operation to l/r operands
 func applyGT(l, r Expr) (*BooleanLiteral, error) {
-	var (
-		a, b float64
-		err  error
-	)
-	a, err = getNumber(l)
-	if err != nil {
-		return nil, err
+	if isOrdered(l) {
+		c, err := compareOrdered(l, r)
+		if err != nil {
+			return nil, err
+		}
+		return &BooleanLiteral{Val: c > 0}, nil
 	}
-	b, err = getNumber(r)
+
+	a, b, err := getNumberLiterals(l, r)
 	if err != nil {
 		return nil, err
 	}
-	return &BooleanLiteral{Val: (a > b)}, nil
+	return &BooleanLiteral{Val: numberLess(b, a)}, nil
 }
 
 // applyGTE applies >= operation to l/r operands
-func applyGTE(l, r Expr) (*BooleanLiteral, error) {
-	var (
-		a, b float64
-		err  error
-	)
-	a, err = getNumber(l)
-	if err != nil {
-		return nil, err
+func applyGTE(l, r Expr, eps float64) (*BooleanLiteral, error) {
+	if isOrdered(l) {
+		c, err := compareOrdered(l, r)
+		if err != nil {
+			return nil, err
+		}
+		return &BooleanLiteral{Val: c >= 0}, nil
 	}
-	b, err = getNumber(r)
+
+	a, b, err := getNumberLiterals(l, r)
 	if err != nil {
 		return nil, err
 	}
-	return &BooleanLiteral{Val: (a > b) || float64Equal(a, b, defaultEpsilon)}, nil
+	return &BooleanLiteral{Val: numberLess(b, a) || numberEqual(a, b, eps)}, nil
 }
 
 // applyLT applies < operation to l/r operands
 func applyLT(l, r Expr) (*BooleanLiteral, error) {
-	var (
-		a, b float64
-		err  error
-	)
-	a, err = getNumber(l)
-	if err != nil {
-		return nil, err
+	if isOrdered(l) {
+		c, err := compareOrdered(l, r)
+		if err != nil {
+			return nil, err
+		}
+		return &BooleanLiteral{Val: c < 0}, nil
 	}
-	b, err = getNumber(r)
+
+	a, b, err := getNumberLiterals(l, r)
 	if err != nil {
 		return nil, err
 	}
-	return &BooleanLiteral{Val: (a < b)}, nil
+	return &BooleanLiteral{Val: numberLess(a, b)}, nil
 }
 
 // applyLTE applies <= operation to l/r operands
-func applyLTE(l, r Expr) (*BooleanLiteral, error) {
-	var (
-		a, b float64
-		err  error
-	)
-	a, err = getNumber(l)
-	if err != nil {
-		return falseExpr, err
+func applyLTE(l, r Expr, eps float64) (*BooleanLiteral, error) {
+	if isOrdered(l) {
+		c, err := compareOrdered(l, r)
+		if err != nil {
+			return nil, err
+		}
+		return &BooleanLiteral{Val: c <= 0}, nil
 	}
-	b, err = getNumber(r)
+
+	a, b, err := getNumberLiterals(l, r)
 	if err != nil {
 		return falseExpr, err
 	}
-	return &BooleanLiteral{Val: (a < b) || float64Equal(a, b, defaultEpsilon)}, nil
+	return &BooleanLiteral{Val: numberLess(a, b) || numberEqual(a, b, eps)}, nil
+}
+
+// comparesNullAsFalse reports whether op is an ordering or pattern
+// comparison, which does not hold for a NULL operand as in SQL, negated or
+// not
+func comparesNullAsFalse(op Token) bool {
+	switch op {
+	case LT, LTE, GT, GTE, EREG, NEREG, STARTSWITH, NOTSTARTSWITH, ENDSWITH, NOTENDSWITH,
+		LIKE, NOTLIKE, ILIKE, NOTILIKE, MATCHES, NOTMATCHES:
+		return true
+	}
+	return false
+}
+
+// isNull reports whether e is NULL
+func isNull(e Expr) bool {
+	_, ok := e.(*NullLiteral)
+	return ok
+}
+
+// isOrdered reports whether e is a string, a time or a duration
+func isOrdered(e Expr) bool {
+	switch e.(type) {
+	case *StringLiteral, *TimeLiteral, *DurationLiteral:
+		return true
+	}
+	return false
+}
+
+// compareOrdered compares two strings, two times or two durations and
+// returns -1, 0 or +1 depending on whether l is before, equal to or after r.
+// Strings are ordered lexicographically by bytes.
+func compareOrdered(l, r Expr) (int, error) {
+	switch a := l.(type) {
+	case *StringLiteral:
+		b, ok := r.(*StringLiteral)
+		if !ok {
+			return 0, typeMismatch(l, r)
+		}
+		return strings.Compare(a.Val, b.Val), nil
+	case *TimeLiteral:
+		b, ok := r.(*TimeLiteral)
+		if !ok {
+			return 0, typeMismatch(l, r)
+		}
+		if a.Val.Before(b.Val) {
+			return -1, nil
+		} else if a.Val.After(b.Val) {
+			return 1, nil
+		}
+		return 0, nil
+	case *DurationLiteral:
+		b, ok := r.(*DurationLiteral)
+		if !ok {
+			return 0, typeMismatch(l, r)
+		}
+		if a.Val < b.Val {
+			return -1, nil
+		} else if a.Val > b.Val {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, typeMismatch(l, r)
 }
 
 // getBoolean performs type assertion and returns boolean value or error
@@ -582,48 +1711,77 @@ func getBoolean(e Expr) (bool, error) {
 	case *BooleanLiteral:
 		return n.Val, nil
 	default:
-		return false, fmt.Errorf("Literal is not a boolean: %v", n)
+		return false, typeMismatch(e, nil)
 	}
 }
 
-// getString performs type assertion and returns string value or error
-func getString(e Expr) (string, error) {
+// getRegexp returns the regular expression of a pattern operand, which
+// is compiled at parse time unless it is only known at evaluation
+func getRegexp(e Expr) (*regexp.Regexp, error) {
 	switch n := e.(type) {
-	case *StringLiteral:
+	case *RegexLiteral:
 		return n.Val, nil
+	case *StringLiteral:
+		return regexCache.compile(n.Val)
 	default:
-		return "", fmt.Errorf("Literal is not a string: %v", n)
+		return nil, typeMismatch(e, nil)
 	}
 }
 
-// getSliceNumber performs type assertion and returns []float64 value or error
-func getSliceNumber(e Expr) ([]float64, error) {
+// getString performs type assertion and returns string value or error
+func getString(e Expr) (string, error) {
 	switch n := e.(type) {
-	case *SliceNumberLiteral:
+	case *StringLiteral:
 		return n.Val, nil
 	default:
-		return []float64{}, fmt.Errorf("Literal is not a slice of float64: %v", n)
+		return "", typeMismatch(e, nil)
 	}
 }
 
-// getMapString performs type assertion and returns map[string]struct{} value or error
-func getMapString(e Expr) (map[string]struct{}, error) {
+// getNumber performs type assertion and returns float64 value or error
+func getNumber(e Expr) (float64, error) {
 	switch n := e.(type) {
-	case *SliceStringLiteral:
-		return n.m, nil
+	case *NumberLiteral:
+		return n.Val, nil
 	default:
-		return nil, fmt.Errorf("Literal is not a slice of string: %v", n)
+		return 0, typeMismatch(e, nil)
 	}
 }
 
-// getNumber performs type assertion and returns float64 value or error
-func getNumber(e Expr) (float64, error) {
+// getNumberLiteral performs type assertion and returns the number or error
+func getNumberLiteral(e Expr) (*NumberLiteral, error) {
 	switch n := e.(type) {
 	case *NumberLiteral:
-		return n.Val, nil
+		return n, nil
 	default:
-		return 0, fmt.Errorf("Literal is not a number: %v", n)
+		return nil, typeMismatch(e, nil)
+	}
+}
+
+// getNumberLiterals performs type assertion of both operands and returns the numbers or error
+func getNumberLiterals(l, r Expr) (*NumberLiteral, *NumberLiteral, error) {
+	a, err := getNumberLiteral(l)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := getNumberLiteral(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+// getNumbers performs type assertion of both operands and returns their float64 values or error
+func getNumbers(l, r Expr) (float64, float64, error) {
+	a, err := getNumber(l)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := getNumber(r)
+	if err != nil {
+		return 0, 0, err
 	}
+	return a, b, nil
 }
 
 func float64Equal(a float64, b float64, epsilon float64) bool {
@@ -0,0 +1,404 @@
+package conditions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertEvaluations evaluates each test with opts, interpreted and compiled.
+func assertEvaluations(t *testing.T, tests []evalTest, opts ...EvalOption) {
+	t.Helper()
+	for _, test := range tests {
+		r, err := Evaluate(mustParse(t, test.cond), test.args, opts...)
+		assert.Equal(t, test.result, r, test.cond)
+		if test.isErr {
+			assert.Error(t, err, test.cond)
+		} else {
+			assert.NoError(t, err, test.cond)
+		}
+		assertSameEvaluation(t, test.cond, test.args, opts...)
+	}
+}
+
+func TestNowAndTimeArithmetic(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	assertEvaluations(t, []evalTest{
+		{`{last_seen} < now() - 24h`, map[string]interface{}{"last_seen": now.Add(-25 * time.Hour)}, true, false},
+		{`{last_seen} < now() - 24h`, map[string]interface{}{"last_seen": now.Add(-23 * time.Hour)}, false, false},
+		{`{created_at} + 7d > now()`, map[string]interface{}{"created_at": now.Add(-6 * 24 * time.Hour)}, true, false},
+		{`{created_at} + 7d > now()`, map[string]interface{}{"created_at": now.Add(-8 * 24 * time.Hour)}, false, false},
+		{`1h + {created_at} > now()`, map[string]interface{}{"created_at": now}, true, false},
+		{`now() - {created_at} >= 2h`, map[string]interface{}{"created_at": now.Add(-2 * time.Hour)}, true, false},
+		{`now() == 2024-06-15T12:00:00Z`, nil, true, false},
+		{`now() - 1h30m + 30m == 2024-06-15T11:00:00Z`, nil, true, false},
+		{`{timeout} * 2 == 1h`, map[string]interface{}{"timeout": 30 * time.Minute}, true, false},
+		{`2 * {timeout} == 1h`, map[string]interface{}{"timeout": 30 * time.Minute}, true, false},
+		{`{timeout} / 4 == 15s`, map[string]interface{}{"timeout": time.Minute}, true, false},
+		{`{timeout} / 0 == 15s`, map[string]interface{}{"timeout": time.Minute}, false, true},
+		{`{timeout} - 1m == 0s`, map[string]interface{}{"timeout": time.Minute}, true, false},
+		{`now() + now() > now()`, nil, false, true},
+		{`now() - 1 > now()`, nil, false, true},
+		{`{timeout} + 1 > 1m`, map[string]interface{}{"timeout": time.Minute}, false, true},
+		{`now(1) > 2024-01-01`, nil, false, true},
+	}, WithClock(clock))
+}
+
+func TestNowReadsClockOncePerEvaluation(t *testing.T) {
+	calls := 0
+	clock := func() time.Time {
+		calls++
+		return time.Date(2024, 6, 15, 12, 0, 0, calls, time.UTC)
+	}
+
+	expr := mustParse(t, `now() == now() AND now() - now() == 0s`)
+	r, err := Evaluate(expr, nil, WithClock(clock))
+	assert.NoError(t, err)
+	assert.True(t, r)
+	assert.Equal(t, 1, calls)
+
+	// Without clock the current time is used.
+	r, err = Evaluate(mustParse(t, `now() > 2024-01-01 AND now() - 1m < now()`), nil)
+	assert.NoError(t, err)
+	assert.True(t, r)
+}
+
+func TestBetween(t *testing.T) {
+	expr := mustParse(t, `{x} NOT BETWEEN 1 AND {y} + 1`)
+	assert.Equal(t, `x NOT BETWEEN 1.000 AND y + 1.000`, expr.String())
+	assert.Equal(t, []string{"x", "y"}, Variables(expr))
+}
+
+func TestRegexFlags(t *testing.T) {
+	_, err := NewParser(strings.NewReader(`{a} =~ /x/g`)).Parse()
+	assert.EqualError(t, err, "unknown regular expression flag 'g' at line 1, column 8")
+}
+
+func TestNull(t *testing.T) {
+	expr := mustParse(t, `EXISTS {a} AND {b} IS NOT NULL`)
+	assert.Equal(t, `EXISTS a AND b IS NOT NULL`, expr.String())
+	assert.ElementsMatch(t, []string{"a", "b"}, Variables(expr))
+
+	// Resolvers report absent keys with errors matching ErrArgumentNotFound,
+	// any other error fails the evaluation.
+	failing := resolverFunc(func(key string) (interface{}, error) { return nil, errors.New("timeout") })
+	_, err := EvaluateWithArgResolver(mustParse(t, `{a} IS NULL`), failing)
+	assert.EqualError(t, err, "argument a not resolved: timeout")
+	_, err = EvaluateWithArgResolver(mustParse(t, `EXISTS {a}`), failing)
+	assert.EqualError(t, err, "argument a not resolved: timeout")
+
+	_, err = NewMapArgResolver(nil).Resolve("a")
+	assert.True(t, errors.Is(err, ErrArgumentNotFound))
+	assert.EqualError(t, err, "argument by key a not found")
+}
+
+type resolverFunc func(key string) (interface{}, error)
+
+func (f resolverFunc) Resolve(key string) (interface{}, error) { return f(key) }
+
+func TestMissingVariables(t *testing.T) {
+	assertEvaluations(t, []evalTest{
+		{`{promo} == "X" OR {tier} == "gold"`, map[string]interface{}{"tier": "gold"}, true, false},
+		{`{promo} IS NULL`, nil, true, false},
+		{`{promo} != "X"`, nil, true, false},
+		{`{count} > 1`, nil, false, false},
+		{`{count} < 1`, nil, false, false},
+		{`{count} BETWEEN 1 AND 2`, nil, false, false},
+		{`{promo} LIKE "X%"`, nil, false, false},
+		{`{promo} NOT LIKE "X%"`, nil, false, false},
+		{`{promo} MATCHES "X*"`, nil, false, false},
+		{`{promo} =~ /X/`, nil, false, false},
+		{`{count} > 1 OR {tier} == "gold"`, map[string]interface{}{"tier": "gold"}, true, false},
+		{`{count} + 1 > 1`, nil, false, true},
+	}, WithMissingVariables(MissingVariableNull))
+
+	assertEvaluations(t, []evalTest{
+		{`{promo} == "X" OR {tier} == "gold"`, map[string]interface{}{"tier": "gold"}, true, false},
+		{`{promo} == "X" OR {tier} == "gold"`, map[string]interface{}{"tier": "silver"}, false, false},
+		{`{promo} != "X"`, nil, false, false},
+		{`NOT {promo} == "X"`, nil, true, false},
+		{`{count} + 1 > 1`, nil, false, false},
+		{`len({tags}) > 1 OR {flag}`, map[string]interface{}{"flag": true}, true, false},
+		{`{flag} NAND true`, nil, true, false},
+		{`{flag}`, nil, false, false},
+		{`{count} BETWEEN 1 AND 2`, nil, false, false},
+		{`{count} > "x"`, map[string]interface{}{"count": 1}, false, true},
+	}, WithMissingVariables(MissingVariableFalse))
+
+	defaults := WithDefaults(map[string]interface{}{"tier": "bronze"})
+	assertEvaluations(t, []evalTest{
+		{`{tier} == "bronze"`, nil, true, false},
+		{`{tier} == "bronze"`, map[string]interface{}{"tier": "gold"}, false, false},
+		{`{tier} IS NOT NULL AND NOT EXISTS {tier}`, nil, true, false},
+		{`{tier} == "bronze" AND {promo} == "X"`, nil, false, true},
+	}, defaults)
+	assertEvaluations(t, []evalTest{
+		{`{tier} == "bronze" AND {promo} IS NULL`, nil, true, false},
+	}, defaults, WithMissingVariables(MissingVariableNull))
+	assertEvaluations(t, []evalTest{
+		{`{tier} == "bronze" AND {limit} == 2`, nil, true, false},
+	}, defaults, WithDefaults(map[string]interface{}{"limit": 2}))
+
+	// Only missing variables are recovered, failing resolvers are not.
+	failing := resolverFunc(func(key string) (interface{}, error) { return nil, errors.New("timeout") })
+	_, err := EvaluateWithArgResolver(mustParse(t, `{a} == 1 OR true`), failing, WithMissingVariables(MissingVariableFalse))
+	assert.EqualError(t, err, "argument a not resolved: timeout")
+}
+
+// tagSet is a StringCollection implemented by the caller.
+type tagSet map[string]struct{}
+
+func (s tagSet) Has(val string) bool { _, ok := s[val]; return ok }
+func (s tagSet) String() string      { return fmt.Sprint(map[string]struct{}(s)) }
+
+func TestArgumentsAreNotCopied(t *testing.T) {
+	ids := []int{1}
+	args := NewMapArgResolver(map[string]interface{}{"ids": ids})
+	prog, err := Compile(mustParse(t, `{ids} CONTAINS 2`))
+	assert.NoError(t, err)
+	r, err := prog.Eval(args)
+	assert.NoError(t, err)
+	assert.False(t, r)
+	ids[0] = 2
+	r, err = prog.Eval(args)
+	assert.NoError(t, err)
+	assert.True(t, r)
+}
+
+// countingSet is a StringCollection counting its lookups.
+type countingSet struct {
+	tagSet
+	lookups int
+}
+
+func (s *countingSet) Has(val string) bool { s.lookups++; return s.tagSet.Has(val) }
+
+func TestCollectionsAreNotScanned(t *testing.T) {
+	tags := &countingSet{tagSet: tagSet{"a": {}, "b": {}, "c": {}}}
+	args := map[string]interface{}{"tags": tags}
+	expr := mustParse(t, `{tags} CONTAINS "c" AND "d" NOT IN {tags}`)
+
+	r, err := Evaluate(expr, args)
+	assert.NoError(t, err)
+	assert.True(t, r)
+	assert.Equal(t, 2, tags.lookups)
+
+	prog, err := Compile(expr)
+	assert.NoError(t, err)
+	r, err = prog.Eval(NewMapArgResolver(args))
+	assert.NoError(t, err)
+	assert.True(t, r)
+	assert.Equal(t, 4, tags.lookups)
+}
+
+type (
+	score    int
+	level    uint8
+	ratio    float32
+	label    string
+	flag     bool
+	labels   []string
+	scores   []score
+	readings []float64
+)
+
+// exactID is 2^53 + 1, the nearest float64 is 2^53.
+const exactID = 9007199254740993
+
+func TestExactIntegers(t *testing.T) {
+	assert.Equal(t, `a == 9007199254740993.000`, mustParse(t, `{a} == 9007199254740993`).String())
+	assert.Equal(t, `a IN [9007199254740993 1 2.5]`, mustParse(t, `{a} IN [9007199254740993, 1, 2.5]`).String())
+	assert.Equal(t, `a IN [0.5 1.5]`, mustParse(t, `{a} IN [0.5, 1.5]`).String())
+}
+
+// countingResolver records the keys resolved from its arguments.
+type countingResolver struct {
+	args     map[string]interface{}
+	resolved []string
+}
+
+func (r *countingResolver) Resolve(key string) (interface{}, error) {
+	r.resolved = append(r.resolved, key)
+	return NewMapArgResolver(r.args).Resolve(key)
+}
+
+func TestShortCircuit(t *testing.T) {
+	var tests = []struct {
+		cond     string
+		args     map[string]interface{}
+		result   bool
+		isErr    bool
+		resolved []string
+	}{
+		{`{user} != "" AND {expensive} > 3`, map[string]interface{}{"user": ""}, false, false, []string{"user"}},
+		{`{user} != "" AND {expensive} > 3`, map[string]interface{}{"user": "john", "expensive": 4}, true, false, []string{"user", "expensive"}},
+		{`{user} != "" AND {expensive} > 3`, map[string]interface{}{"user": "john"}, false, true, []string{"user", "expensive"}},
+		{`{a} OR {b}`, map[string]interface{}{"a": true}, true, false, []string{"a"}},
+		{`{a} OR {b}`, map[string]interface{}{"a": false, "b": false}, false, false, []string{"a", "b"}},
+		{`{a} NAND {b}`, map[string]interface{}{"a": false}, true, false, []string{"a"}},
+		{`{a} NAND {b}`, map[string]interface{}{"a": true, "b": true}, false, false, []string{"a", "b"}},
+		{`{a} XOR {b}`, map[string]interface{}{"a": true, "b": true}, false, false, []string{"a", "b"}},
+		{`{a} AND {b} OR {c} AND {d}`, map[string]interface{}{"a": false, "c": true, "d": true}, true, false, []string{"a", "c", "d"}},
+		{`({a} OR {b}) AND ({c} OR {d})`, map[string]interface{}{"a": true, "c": false, "d": true}, true, false, []string{"a", "c", "d"}},
+		{`NOT {a} AND {b}`, map[string]interface{}{"a": true}, false, false, []string{"a"}},
+		{`{a} == 1 AND {b} == 1`, map[string]interface{}{"a": 2}, false, false, []string{"a"}},
+		{`{a} + {b} > 1 AND {b}`, map[string]interface{}{"a": 0, "b": 0}, false, false, []string{"a", "b"}},
+		{`{a} AND {b}`, map[string]interface{}{"a": 1}, false, true, []string{"a", "b"}},
+		{`{a} == 1 OR {a} == 2`, map[string]interface{}{"a": 3}, false, false, []string{"a"}},
+		{`{tags} CONTAINS "x" OR {tags} CONTAINS "y"`, map[string]interface{}{"tags": []string{"y"}}, true, false, []string{"tags"}},
+	}
+
+	for _, test := range tests {
+		resolver := &countingResolver{args: test.args}
+		r, err := EvaluateWithArgResolver(mustParse(t, test.cond), resolver)
+		assert.Equal(t, test.result, r, test.cond)
+		if test.isErr {
+			assert.Error(t, err, test.cond)
+		} else {
+			assert.NoError(t, err, test.cond)
+		}
+		assert.Equal(t, test.resolved, resolver.resolved, test.cond)
+	}
+
+	// A missing variable taken as false short-circuits as well.
+	resolver := &countingResolver{}
+	r, err := EvaluateWithArgResolver(mustParse(t, `{a} AND {b}`), resolver, WithMissingVariables(MissingVariableFalse))
+	assert.NoError(t, err)
+	assert.False(t, r)
+	assert.Equal(t, []string{"a"}, resolver.resolved)
+
+	// So does false in three-valued evaluations, UNKNOWN does not.
+	resolver = &countingResolver{args: map[string]interface{}{"a": false}}
+	tr, err := EvaluateTristateWithArgResolver(mustParse(t, `{a} AND {b} OR {c}`), resolver)
+	assert.NoError(t, err)
+	assert.Equal(t, TristateUnknown, tr)
+	assert.Equal(t, []string{"a", "c"}, resolver.resolved)
+}
+
+func TestEvaluator(t *testing.T) {
+	strict := NewEvaluator(WithEpsilon(0))
+	loose := NewEvaluator(WithEpsilon(1e-3))
+
+	var tests = []struct {
+		cond   string
+		args   map[string]interface{}
+		strict bool
+		loose  bool
+	}{
+		{`{a} == 0.1`, map[string]interface{}{"a": 0.1001}, false, true},
+		{`{a} >= 0.1`, map[string]interface{}{"a": 0.0999}, false, true},
+		{`{a} <= 0.1`, map[string]interface{}{"a": 0.1001}, false, true},
+		{`{a} BETWEEN 0.1 AND 0.2`, map[string]interface{}{"a": 0.0999}, false, true},
+		{`{a} IN [0.1, 0.2]`, map[string]interface{}{"a": 0.1001}, false, true},
+		{`{ids} CONTAINS 1.0001`, map[string]interface{}{"ids": []int{1, 2}}, false, true},
+		{`{ids} CONTAINS 2.0001`, map[string]interface{}{"ids": []int64{1, 2}}, false, true},
+		{`{ids} CONTAINS 1.5001`, map[string]interface{}{"ids": []float32{1.5}}, false, true},
+		{`{a} == 0.1`, map[string]interface{}{"a": 0.1}, true, true},
+	}
+
+	var wg sync.WaitGroup
+	for _, test := range tests {
+		expr := mustParse(t, test.cond)
+		for _, e := range []struct {
+			evaluator *Evaluator
+			result    bool
+		}{{strict, test.strict}, {loose, test.loose}} {
+			wg.Add(1)
+			go func(e *Evaluator, result bool) {
+				defer wg.Done()
+				r, err := e.Evaluate(expr, test.args)
+				assert.NoError(t, err, test.cond)
+				assert.Equal(t, result, r, test.cond)
+
+				prog, err := e.Compile(expr)
+				assert.NoError(t, err, test.cond)
+				r, err = prog.Eval(NewMapArgResolver(test.args))
+				assert.NoError(t, err, test.cond)
+				assert.Equal(t, result, r, test.cond)
+			}(e.evaluator, e.result)
+		}
+		wg.Wait()
+	}
+
+	// The package functions evaluate with the default settings.
+	r, err := Evaluate(mustParse(t, `{a} == 0.1`), map[string]interface{}{"a": 0.1000001})
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	// Options other than the epsilon apply alike.
+	e := NewEvaluator(WithMissingVariables(MissingVariableFalse), WithDefaults(map[string]interface{}{"b": 2}))
+	r, err = e.Evaluate(mustParse(t, `{a} == 1 OR {b} == 2`), nil)
+	assert.NoError(t, err)
+	assert.True(t, r)
+	tr, err := e.EvaluateTristate(mustParse(t, `{a} == 1`), NewMapArgResolver(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, TristateUnknown, tr)
+	r, err = e.EvaluateWithArgResolver(mustParse(t, `{a} == 1`), NewMapArgResolver(nil))
+	assert.NoError(t, err)
+	assert.False(t, r)
+}
+
+func TestLimits(t *testing.T) {
+	e := NewEvaluator(WithLimits(Limits{MaxDepth: 3, MaxNodes: 7}))
+	args := map[string]interface{}{"a": 1, "b": 2, "c": 3}
+
+	var tests = []struct {
+		cond string
+		msg  string
+	}{
+		{`{a} == 1`, ""},
+		{`{a} == 1 AND {b} == 2`, ""},
+		{`{a} == 1 AND ({b} == 2)`, "expression deeper than 3 nodes: limit exceeded"},
+		{`{a} == 1 AND {b} == 2 AND {c} == 3`, "expression deeper than 3 nodes: limit exceeded"},
+		{`len("a") + len("b") + 1 > 1`, "expression deeper than 3 nodes: limit exceeded"},
+		{`{a} IN [1] AND {b} IN [2] AND {c} IN [3]`, "expression deeper than 3 nodes: limit exceeded"},
+	}
+
+	for _, test := range tests {
+		expr := mustParse(t, test.cond)
+		_, err := e.Evaluate(expr, args)
+		_, compileErr := e.Compile(expr)
+		if test.msg == "" {
+			assert.NoError(t, err, test.cond)
+			assert.NoError(t, compileErr, test.cond)
+			continue
+		}
+		assert.EqualError(t, err, test.msg, test.cond)
+		assert.True(t, errors.Is(err, ErrLimitExceeded), test.cond)
+		assert.EqualError(t, compileErr, test.msg, test.cond)
+	}
+
+	e = NewEvaluator(WithLimits(Limits{MaxNodes: 5}))
+	_, err := e.Evaluate(mustParse(t, `{a} == 1 OR {b} == 2`), args)
+	assert.EqualError(t, err, "expression of more than 5 nodes: limit exceeded")
+}
+
+func TestCoercion(t *testing.T) {
+	assertEvaluations(t, []evalTest{
+		{`{a} == "123"`, map[string]interface{}{"a": 123}, true, false},
+		{`{a} == 123`, map[string]interface{}{"a": "123.0"}, true, false},
+		{`{a} != 1`, map[string]interface{}{"a": "2"}, true, false},
+		{`{a} > 10`, map[string]interface{}{"a": "9"}, false, false},
+		{`{a} <= 1.5`, map[string]interface{}{"a": "1.5"}, true, false},
+		{`{a} BETWEEN 1 AND 10`, map[string]interface{}{"a": "5"}, true, false},
+		{`{a} == true`, map[string]interface{}{"a": "true"}, true, false},
+		{`{a} == false`, map[string]interface{}{"a": "true"}, false, false},
+		{`{a} IN [1, 2, 3]`, map[string]interface{}{"a": "2"}, true, false},
+		{`{a} NOT IN ["1", "2"]`, map[string]interface{}{"a": 3}, true, false},
+		{`{a} IN ["1", "2"]`, map[string]interface{}{"a": 2.0}, true, false},
+		{`{ids} CONTAINS "2"`, map[string]interface{}{"ids": []int{1, 2}}, true, false},
+		{`{tags} CONTAINS 2`, map[string]interface{}{"tags": []string{"1", "2"}}, true, false},
+		{`{a} =~ "^12"`, map[string]interface{}{"a": 123}, true, false},
+		{`{a} !~ "\\."`, map[string]interface{}{"a": 1.0}, true, false},
+		{`{a} == "abc"`, map[string]interface{}{"a": 1}, false, true},
+		{`{a} == "yes"`, map[string]interface{}{"a": true}, false, true},
+		{`{a} IN [1, 2]`, map[string]interface{}{"a": "x"}, false, true},
+	}, WithCoercion(CoercionStrings))
+}
@@ -0,0 +1,179 @@
+package conditions
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explanation is the evaluation of an expression recorded by Explain.
+type Explanation struct {
+	// Result of the evaluation, false if it failed
+	Result bool
+	// Trace of the root node of the expression
+	Trace *Trace
+	// Variables read by the evaluation, in the order they were first read
+	Variables []Variable
+}
+
+// Trace is the evaluation of a node. Parentheses have no trace of their
+// own, so the children of a node are the traces of its operands.
+type Trace struct {
+	Expr Expr
+	// Value the node evaluated to, nil if it failed or was not evaluated
+	Value Expr
+	// Err is the error the node failed with
+	Err error
+	// Evaluated is false for the right operand of AND, OR and NAND when
+	// the left one decided the result
+	Evaluated bool
+	// Decisive marks the operand of AND, OR or NAND which settled the
+	// result: the left one if it short-circuited, the right one otherwise
+	Decisive bool
+	Children []*Trace
+}
+
+// Variable is an argument read by an evaluation.
+type Variable struct {
+	Name  string
+	Value interface{}
+	// Err is the error resolving the argument
+	Err error
+	// Missing is true if the resolver found no argument, in which case
+	// Value is the default of the variable, if any
+	Missing bool
+}
+
+// Explain evaluates the expression like EvaluateWithArgResolver and
+// records the value of every node and the variables read. The
+// explanation is returned even if the evaluation fails, in which case the
+// failing nodes carry the error.
+func Explain(expr Expr, args ArgResolver, opts ...EvalOption) (*Explanation, error) {
+	return NewEvaluator(opts...).Explain(expr, args)
+}
+
+// Explain evaluates the expression and records the evaluation, see the
+// package function of the same name.
+func (e *Evaluator) Explain(expr Expr, args ArgResolver) (*Explanation, error) {
+	ev, err := e.start(expr, args)
+	if err != nil {
+		return nil, err
+	}
+	ev.tracer = &tracer{}
+	result, err := ev.evaluate(expr)
+
+	x := &Explanation{Result: result, Trace: ev.tracer.root}
+	for _, v := range ev.vars {
+		x.Variables = append(x.Variables, Variable{Name: v.name, Value: v.arg, Err: v.err, Missing: v.missing})
+	}
+	return x, err
+}
+
+// String renders the explanation: each node of the condition with its
+// value, indented below its parent, followed by the variables read.
+// Literals, whose value is plain, are left out.
+func (e *Explanation) String() string {
+	var b strings.Builder
+	if e.Trace != nil {
+		e.Trace.render(&b, 0)
+	}
+	if len(e.Variables) != 0 {
+		b.WriteString("variables:\n")
+	}
+	for _, v := range e.Variables {
+		switch {
+		case v.Missing && v.Err == nil:
+			fmt.Fprintf(&b, "  %s = %s (default)\n", v.Name, formatArgument(v.Value))
+		case v.Missing:
+			fmt.Fprintf(&b, "  %s not found\n", v.Name)
+		case v.Err != nil:
+			fmt.Fprintf(&b, "  %s error: %v\n", v.Name, v.Err)
+		default:
+			fmt.Fprintf(&b, "  %s = %s\n", v.Name, formatArgument(v.Value))
+		}
+	}
+	return b.String()
+}
+
+func (t *Trace) render(b *strings.Builder, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(t.Expr.String())
+	switch {
+	case !t.Evaluated:
+		b.WriteString(" => not evaluated")
+	case t.Err != nil:
+		fmt.Fprintf(b, " => error: %v", t.Err)
+	default:
+		fmt.Fprintf(b, " => %s", t.Value)
+	}
+	if t.Decisive {
+		b.WriteString(" (decisive)")
+	}
+	b.WriteString("\n")
+
+	for _, c := range t.Children {
+		if c.Evaluated && c.Value == c.Expr {
+			continue
+		}
+		c.render(b, depth+1)
+	}
+}
+
+// formatArgument formats the argument of a variable, quoting strings.
+func formatArgument(arg interface{}) string {
+	if s, ok := arg.(string); ok {
+		return Quote(s)
+	}
+	return fmt.Sprintf("%v", arg)
+}
+
+// tracer records the traces of an evaluation.
+type tracer struct {
+	root *Trace
+	// traces of the nodes being evaluated, innermost last
+	stack []*Trace
+}
+
+// push starts the trace of a node.
+func (t *tracer) push(expr Expr) {
+	tr := &Trace{Expr: expr}
+	if len(t.stack) == 0 {
+		t.root = tr
+	} else {
+		parent := t.stack[len(t.stack)-1]
+		parent.Children = append(parent.Children, tr)
+	}
+	t.stack = append(t.stack, tr)
+}
+
+// pop ends the trace of the innermost node with its result.
+func (t *tracer) pop(v Expr, err error) {
+	tr := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	tr.Evaluated = true
+	if err != nil {
+		tr.Err = err
+	} else {
+		tr.Value = v
+	}
+}
+
+// skip records an operand of the innermost node which is not evaluated.
+func (t *tracer) skip(expr Expr) {
+	for {
+		p, ok := expr.(*ParenExpr)
+		if !ok {
+			break
+		}
+		expr = p.Expr
+	}
+	parent := t.stack[len(t.stack)-1]
+	parent.Children = append(parent.Children, &Trace{Expr: expr})
+}
+
+// decide marks the i-th operand of the innermost node as decisive.
+func (t *tracer) decide(i int) {
+	parent := t.stack[len(t.stack)-1]
+	if i < len(parent.Children) {
+		parent.Children[i].Decisive = true
+	}
+}
@@ -0,0 +1,70 @@
+package conditions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	expr := mustParse(t, `{tier} == "gold" OR ({total} > 100 AND {country} IN ["DE", "AT"])`)
+	args := NewMapArgResolver(map[string]interface{}{"tier": "silver", "total": 80, "country": "DE"})
+
+	e, err := Explain(expr, args)
+	assert.NoError(t, err)
+	assert.False(t, e.Result)
+	assert.Equal(t, OR, e.Trace.Expr.(*BinaryExpr).Op)
+	assert.Len(t, e.Trace.Children, 2)
+
+	and := e.Trace.Children[1]
+	assert.True(t, and.Decisive)
+	assert.Equal(t, AND, and.Expr.(*BinaryExpr).Op)
+	assert.True(t, and.Children[0].Decisive)
+	assert.False(t, and.Children[1].Evaluated)
+
+	assert.Equal(t, []Variable{{Name: "tier", Value: "silver"}, {Name: "total", Value: 80}}, e.Variables)
+	assert.Equal(t, `tier == "gold" OR (total > 100.000 AND country IN [DE AT]) => false
+  tier == "gold" => false
+    tier => "silver"
+  total > 100.000 AND country IN [DE AT] => false (decisive)
+    total > 100.000 => false (decisive)
+      total => 80.000
+    country IN [DE AT] => not evaluated
+variables:
+  tier = "silver"
+  total = 80
+`, e.String())
+}
+
+func TestExplainErrors(t *testing.T) {
+	expr := mustParse(t, `{a} IS NULL AND {b} > 1`)
+	e, err := Explain(expr, NewMapArgResolver(nil))
+	assert.EqualError(t, err, "argument b not resolved: argument by key b not found")
+	assert.False(t, e.Result)
+	assert.Equal(t, `a IS NULL AND b > 1.000 => error: argument b not resolved: argument by key b not found
+  a IS NULL => true
+    a => NULL
+  b > 1.000 => error: argument b not resolved: argument by key b not found
+    b => error: argument b not resolved: argument by key b not found
+variables:
+  a not found
+  b not found
+`, e.String())
+
+	// Defaults are shown as such, and missing variables taken as false
+	// keep their error.
+	e, err = Explain(mustParse(t, `{a} OR {b}`), NewMapArgResolver(nil),
+		WithDefaults(map[string]interface{}{"b": true}), WithMissingVariables(MissingVariableFalse))
+	assert.NoError(t, err)
+	assert.True(t, e.Result)
+	assert.Equal(t, `a OR b => true
+  a => error: argument a not resolved: argument by key a not found
+  b => true (decisive)
+variables:
+  a not found
+  b = true (default)
+`, e.String())
+
+	_, err = Explain(nil, NewMapArgResolver(nil))
+	assert.Error(t, err)
+}
@@ -0,0 +1,241 @@
+package conditions
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// builtinFunctions holds the functions callable without any registry.
+var builtinFunctions = NewFunctionRegistry()
+
+// Function is a Go function callable from conditions.
+type Function struct {
+	name     string
+	fn       reflect.Value
+	withErr  bool
+	variadic bool
+	// whether the function is the builtin now, which reads the clock of
+	// the evaluation
+	now bool
+}
+
+// FunctionRegistry holds the functions callable from conditions by name.
+// Names are case-insensitive. It is safe for concurrent use.
+type FunctionRegistry struct {
+	mu    sync.RWMutex
+	funcs map[string]*Function
+}
+
+// NewFunctionRegistry returns a registry holding the builtin functions:
+//
+//	len(v)   number of characters of a string or items of an array or collection
+//	lower(s) s with all letters mapped to their lower case
+//	upper(s) s with all letters mapped to their upper case
+//	now()    current time, see WithClock
+func NewFunctionRegistry() *FunctionRegistry {
+	r := &FunctionRegistry{funcs: make(map[string]*Function)}
+	r.MustRegister("len", builtinLen)
+	r.MustRegister("lower", strings.ToLower)
+	r.MustRegister("upper", strings.ToUpper)
+	r.MustRegister("now", time.Now)
+	r.funcs["now"].now = true
+	return r
+}
+
+// Register makes fn callable under name, replacing any function registered
+// under the same name. fn must be a Go function returning a single value,
+// optionally followed by an error. Its parameters receive the evaluated
+// arguments: numbers as float64 (or any other numeric type when the number
+// fits it), strings, booleans, times as time.Time, durations as
+// time.Duration, arrays as []string or []float64, collections as
+// themselves and NULL as nil, which only parameters that can be nil accept.
+// A parameter of type interface{} accepts any argument.
+func (r *FunctionRegistry) Register(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return fmt.Errorf("function %s is not a func but %T", name, fn)
+	}
+
+	t := v.Type()
+	withErr := t.NumOut() == 2 && t.Out(1) == errorType
+	if t.NumOut() != 1 && !withErr {
+		return fmt.Errorf("function %s must return a single value optionally followed by an error", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.funcs[strings.ToLower(name)] = &Function{
+		name:     name,
+		fn:       v,
+		withErr:  withErr,
+		variadic: t.IsVariadic(),
+	}
+	return nil
+}
+
+// MustRegister is like Register but panics if fn cannot be registered.
+func (r *FunctionRegistry) MustRegister(name string, fn interface{}) {
+	if err := r.Register(name, fn); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the function registered under name.
+func (r *FunctionRegistry) Lookup(name string) (*Function, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fn, ok := r.funcs[strings.ToLower(name)]
+	return fn, ok
+}
+
+// Name returns the name the function was registered with.
+func (f *Function) Name() string { return f.name }
+
+// CheckArity returns an error if the function cannot be called with n arguments.
+func (f *Function) CheckArity(n int) error {
+	in := f.fn.Type().NumIn()
+	if f.variadic && n >= in-1 || n == in {
+		return nil
+	}
+	if f.variadic {
+		return fmt.Errorf("function %s expects at least %d arguments, got %d", f.name, in-1, n)
+	}
+	return fmt.Errorf("function %s expects %d arguments, got %d", f.name, in, n)
+}
+
+// Call calls the function with the evaluated arguments and returns its
+// result as a literal.
+func (f *Function) Call(args []Expr) (Expr, error) {
+	if err := f.CheckArity(len(args)); err != nil {
+		return falseExpr, err
+	}
+
+	t := f.fn.Type()
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var pt reflect.Type
+		if f.variadic && i >= t.NumIn()-1 {
+			pt = t.In(t.NumIn() - 1).Elem()
+		} else {
+			pt = t.In(i)
+		}
+
+		v, err := convertArgument(arg, pt)
+		if err != nil {
+			return falseExpr, fmt.Errorf("argument %d of function %s: %s", i+1, f.name, err)
+		}
+		in[i] = v
+	}
+
+	out := f.fn.Call(in)
+	if f.withErr && !out[1].IsNil() {
+		return falseExpr, fmt.Errorf("function %s: %s", f.name, out[1].Interface())
+	}
+
+	return literalOf("result of "+f.name, out[0].Interface())
+}
+
+// valueOf returns the Go value held by the literal.
+func valueOf(e Expr) interface{} {
+	switch n := e.(type) {
+	case *NumberLiteral:
+		return n.Val
+	case *StringLiteral:
+		return n.Val
+	case *BooleanLiteral:
+		return n.Val
+	case *TimeLiteral:
+		return n.Val
+	case *DurationLiteral:
+		return n.Val
+	case *SliceStringLiteral:
+		return n.Val
+	case *SliceNumberLiteral:
+		return n.Val
+	case *NumberCollectionLiteral:
+		// slices of numbers are passed to functions as []float64
+		if s, ok := n.Val.(numberSlice); ok {
+			return s.float64s()
+		}
+		return n.Val
+	case *StringCollectionLiteral:
+		return n.Val
+	case *RegexLiteral:
+		return n.Val
+	case *NullLiteral:
+		return nil
+	}
+	return e
+}
+
+// convertArgument converts the value of arg to a value of type t.
+func convertArgument(arg Expr, t reflect.Type) (reflect.Value, error) {
+	// Integers convert exactly to integer types.
+	if n, ok := arg.(*NumberLiteral); ok && n.isInteger() {
+		c := reflect.New(t).Elem()
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if n.Kind != NumberInt || c.OverflowInt(n.Int) {
+				return reflect.Value{}, fmt.Errorf("%s does not fit %s", formatNumber(n), t)
+			}
+			c.SetInt(n.Int)
+			return c, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if n.Kind == NumberInt && n.Int < 0 || c.OverflowUint(n.unsigned()) {
+				return reflect.Value{}, fmt.Errorf("%s does not fit %s", formatNumber(n), t)
+			}
+			c.SetUint(n.unsigned())
+			return c, nil
+		}
+	}
+
+	v := valueOf(arg)
+	if v == nil {
+		// NULL is the zero value of the types which can be nil.
+		switch t.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use NULL as %s", t)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(t) {
+		return rv, nil
+	}
+
+	if f, ok := v.(float64); ok {
+		switch t.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return rv.Convert(t), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			c := rv.Convert(t)
+			if back := c.Convert(rv.Type()).Float(); back != f {
+				return reflect.Value{}, fmt.Errorf("%v does not fit %s", f, t)
+			}
+			return c, nil
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %v as %s", v, t)
+}
+
+func builtinLen(v interface{}) (int, error) {
+	switch t := v.(type) {
+	case string:
+		return utf8.RuneCountInString(t), nil
+	case []string:
+		return len(t), nil
+	case []float64:
+		return len(t), nil
+	case Collection:
+		return t.Count(), nil
+	}
+	return 0, fmt.Errorf("cannot get the length of %v", v)
+}
@@ -0,0 +1,172 @@
+package conditions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinFunctions(t *testing.T) {
+	var tests = []struct {
+		cond   string
+		args   map[string]interface{}
+		result bool
+		isErr  bool
+	}{
+		{`len({tags}) > 2`, map[string]interface{}{"tags": []string{"a", "b", "c"}}, true, false},
+		{`len({tags}) > 2`, map[string]interface{}{"tags": []int{1, 2}}, false, false},
+		{`len({name}) == 4`, map[string]interface{}{"name": "Jürg"}, true, false},
+		{`len({numbers}) == 3`, map[string]interface{}{"numbers": TryNewCollection([]interface{}{1, 2, 3})}, true, false},
+		{`len({ids}) == 2`, map[string]interface{}{"ids": TryNewCollection([]interface{}{int64(1 << 53), int64(1<<53 + 1)})}, true, false},
+		{`len({ids}) == 2`, map[string]interface{}{"ids": TryNewCollection([]interface{}{uint64(1<<64 - 1), uint64(1<<64 - 2)})}, true, false},
+		{`len({numbers}) == 2`, map[string]interface{}{"numbers": TryNewCollection([]interface{}{2, 2.0, 1.5})}, true, false},
+		{`len([1, 2]) == 2`, nil, true, false},
+		{`len({flag}) == 2`, map[string]interface{}{"flag": true}, false, true},
+		{`lower({country}) == "de"`, map[string]interface{}{"country": "DE"}, true, false},
+		{`LOWER({country}) == "de"`, map[string]interface{}{"country": "De"}, true, false},
+		{`upper(lower({country})) == "DE"`, map[string]interface{}{"country": "dE"}, true, false},
+		{`lower({country}) == "de"`, map[string]interface{}{"country": 49}, false, true},
+		{`lower({country}, "x") == "de"`, map[string]interface{}{"country": "DE"}, false, true},
+		{`unknown({country})`, map[string]interface{}{"country": "DE"}, false, true},
+	}
+
+	for _, test := range tests {
+		r, err := Evaluate(mustParse(t, test.cond), test.args)
+		assert.Equal(t, test.result, r, test.cond)
+		if test.isErr {
+			assert.Error(t, err, test.cond)
+		} else {
+			assert.NoError(t, err, test.cond)
+		}
+	}
+}
+
+func TestFunctionRegistry(t *testing.T) {
+	reg := NewFunctionRegistry()
+	assert.NoError(t, reg.Register("within", func(v, lo, hi float64) bool { return v >= lo && v <= hi }))
+	assert.NoError(t, reg.Register("repeat", func(s string, n int) string { return strings.Repeat(s, n) }))
+	assert.NoError(t, reg.Register("sum", func(values ...float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	}))
+	assert.NoError(t, reg.Register("fail", func() (bool, error) { return false, errors.New("boom") }))
+	assert.NoError(t, reg.Register("suffix", func(id int64) int64 { return id % 1000 }))
+	assert.NoError(t, reg.Register("byte", func(b uint8) uint8 { return b }))
+	assert.NoError(t, reg.Register("isnil", func(v interface{}) bool { return v == nil }))
+	assert.NoError(t, reg.Register("typeof", func(v interface{}) string { return fmt.Sprintf("%T", v) }))
+	assert.Error(t, reg.Register("nothing", func() {}))
+	assert.Error(t, reg.Register("notfunc", 42))
+
+	var tests = []struct {
+		cond   string
+		args   map[string]interface{}
+		result bool
+		isErr  bool
+	}{
+		{`within({x}, 1, 10)`, map[string]interface{}{"x": 5}, true, false},
+		{`within({x}, 1, 10)`, map[string]interface{}{"x": 11}, false, false},
+		{`within({x}, 1, "10")`, map[string]interface{}{"x": 5}, false, true},
+		{`repeat({s}, 3) == "ababab"`, map[string]interface{}{"s": "ab"}, true, false},
+		{`repeat({s}, 1.5) == "ab"`, map[string]interface{}{"s": "ab"}, false, true},
+		{`sum() == 0`, nil, true, false},
+		{`sum({a}, {b}, 3) == 6`, map[string]interface{}{"a": 1, "b": 2}, true, false},
+		{`fail()`, nil, false, true},
+		{`len({s}) == 2`, map[string]interface{}{"s": "ab"}, true, false},
+		{`suffix({id}) == 993`, map[string]interface{}{"id": int64(1<<53 + 1)}, true, false},
+		{`suffix(9007199254740993) == 993`, nil, true, false},
+		{`byte(255) == 255`, nil, true, false},
+		{`byte(256) == 0`, nil, false, true},
+		{`byte(-1) == 0`, nil, false, true},
+		{`suffix(18446744073709551615) == 0`, nil, false, true},
+		{`isnil(NULL)`, nil, true, false},
+		{`isnil({a})`, map[string]interface{}{"a": nil}, true, false},
+		{`isnil({a})`, map[string]interface{}{"a": 0}, false, false},
+		{`typeof(NULL) == "<nil>"`, nil, true, false},
+		{`typeof(1) == "float64"`, nil, true, false},
+		{`repeat(NULL, 2) == ""`, nil, false, true},
+	}
+
+	for _, test := range tests {
+		r, err := Evaluate(mustParse(t, test.cond), test.args, WithFunctions(reg))
+		assert.Equal(t, test.result, r, test.cond)
+		if test.isErr {
+			assert.Error(t, err, test.cond)
+		} else {
+			assert.NoError(t, err, test.cond)
+		}
+	}
+
+	// Without the registry only the builtin functions are known.
+	_, err := Evaluate(mustParse(t, `sum(1) == 1`), nil)
+	assert.EqualError(t, err, "unknown function sum")
+}
+
+func TestParserFunctionRegistry(t *testing.T) {
+	reg := NewFunctionRegistry()
+	reg.MustRegister("double", func(v float64) float64 { return v * 2 })
+
+	p := NewParser(strings.NewReader(`double({x}) == 4`))
+	p.SetFunctionRegistry(reg)
+	expr, err := p.Parse()
+	assert.NoError(t, err)
+
+	// The function is bound to the call at parse time.
+	r, err := Evaluate(expr, map[string]interface{}{"x": 2})
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	// The registry given at evaluation time takes precedence.
+	other := NewFunctionRegistry()
+	other.MustRegister("double", func(v float64) float64 { return v })
+	r, err = Evaluate(expr, map[string]interface{}{"x": 4}, WithFunctions(other))
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	var tests = []struct {
+		cond string
+		msg  string
+	}{
+		{`triple({x}) == 6`, "unknown function triple at line 1, column 1"},
+		{`{x} == 1 AND double({x}, 2) == 4`, "function double expects 1 arguments, got 2 at line 1, column 14"},
+		{`double({x} == 4`, "found EOF, expected ,, ) at line 1, column 16"},
+		{`double({x} 4)`, "found 4, expected ,, ) at line 1, column 12"},
+		{`double == 4`, "found double, expected " + operandExpected + " at line 1, column 1"},
+	}
+
+	for _, test := range tests {
+		p := NewParser(strings.NewReader(test.cond))
+		p.SetFunctionRegistry(reg)
+		_, err := p.Parse()
+		assert.EqualError(t, err, test.msg, test.cond)
+	}
+
+	p = NewParser(strings.NewReader(`triple({x}, ) == 6 OR double(1, 2)`))
+	p.SetFunctionRegistry(reg)
+	expr, errs := p.ParseAll()
+	assert.Equal(t, "(OR (== <bad expression> 6.000) <bad expression>)", sexpr(expr))
+	if assert.Len(t, errs, 3) {
+		assert.Equal(t, "found ), expected "+operandExpected+" at line 1, column 13", errs[0].Error())
+		assert.Equal(t, "unknown function triple at line 1, column 1", errs[1].Error())
+		assert.Equal(t, "function double expects 1 arguments, got 2 at line 1, column 23", errs[2].Error())
+	}
+}
+
+func TestCallExpr(t *testing.T) {
+	expr := mustParse(t, `lower({a}) == upper({b}, lower({c}))`)
+	assert.Equal(t, `lower(a) == upper(b, lower(c))`, expr.String())
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, Variables(expr))
+
+	names := []string{}
+	WalkFunc(expr, func(n Node) {
+		if call, ok := n.(*CallExpr); ok {
+			names = append(names, call.Name)
+		}
+	})
+	assert.Equal(t, []string{"lower", "upper", "lower"}, names)
+}
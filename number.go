@@ -0,0 +1,155 @@
+package conditions
+
+import (
+	"math"
+	"strconv"
+)
+
+// intNumber returns the number of an integer.
+func intNumber(i int64) NumberLiteral {
+	return NumberLiteral{Val: float64(i), Kind: NumberInt, Int: i}
+}
+
+// uintNumber returns the number of an unsigned integer, held by Int
+// unless it exceeds math.MaxInt64.
+func uintNumber(u uint64) NumberLiteral {
+	if u <= math.MaxInt64 {
+		return intNumber(int64(u))
+	}
+	return NumberLiteral{Val: float64(u), Kind: NumberUint, Uint: u}
+}
+
+// parseNumber parses a number, keeping integers exact.
+func parseNumber(s string) (NumberLiteral, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return intNumber(i), nil
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return uintNumber(u), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return NumberLiteral{Val: f}, err
+}
+
+// formatNumber formats a number in its shortest form, 1 for 1.0.
+func formatNumber(n *NumberLiteral) string {
+	switch n.Kind {
+	case NumberInt:
+		return strconv.FormatInt(n.Int, 10)
+	case NumberUint:
+		return strconv.FormatUint(n.Uint, 10)
+	}
+	return strconv.FormatFloat(n.Val, 'f', -1, 64)
+}
+
+// isInteger reports whether the number is held exactly by Int or Uint.
+func (l *NumberLiteral) isInteger() bool {
+	return l.Kind == NumberInt || l.Kind == NumberUint
+}
+
+// unsigned returns the magnitude of a non-negative integer.
+func (l *NumberLiteral) unsigned() uint64 {
+	if l.Kind == NumberInt {
+		return uint64(l.Int)
+	}
+	return l.Uint
+}
+
+// numberEqual reports whether a and b are equal, exactly if both are
+// integers and within epsilon otherwise.
+func numberEqual(a, b *NumberLiteral, epsilon float64) bool {
+	if a.isInteger() && b.isInteger() {
+		return compareIntegers(a, b) == 0
+	}
+	return float64Equal(a.Val, b.Val, epsilon)
+}
+
+// numberLess reports whether a is less than b, exactly if both are
+// integers.
+func numberLess(a, b *NumberLiteral) bool {
+	if a.isInteger() && b.isInteger() {
+		return compareIntegers(a, b) < 0
+	}
+	return a.Val < b.Val
+}
+
+// compareIntegers compares two integers by sign, then by magnitude, and
+// returns -1, 0 or +1 like compareOrdered.
+func compareIntegers(a, b *NumberLiteral) int {
+	aNeg := a.Kind == NumberInt && a.Int < 0
+	bNeg := b.Kind == NumberInt && b.Int < 0
+	switch {
+	case aNeg && !bNeg:
+		return -1
+	case !aNeg && bNeg:
+		return 1
+	case aNeg:
+		return compareInt64(a.Int, b.Int)
+	}
+	x, y := a.unsigned(), b.unsigned()
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+func compareInt64(x, y int64) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+// intArithmetic applies +, -, * or % to two integers held by Int. It
+// reports false if an operand is not held by Int or the result overflows,
+// for the operation to be applied to floats instead.
+func intArithmetic(op Token, a, b *NumberLiteral) (NumberLiteral, bool) {
+	if a.Kind != NumberInt || b.Kind != NumberInt {
+		return NumberLiteral{}, false
+	}
+	x, y := a.Int, b.Int
+	var r int64
+	switch op {
+	case ADD:
+		r = x + y
+		if (y > 0 && r < x) || (y < 0 && r > x) {
+			return NumberLiteral{}, false
+		}
+	case SUB:
+		r = x - y
+		if (y > 0 && r > x) || (y < 0 && r < x) {
+			return NumberLiteral{}, false
+		}
+	case MUL:
+		if x == 0 || y == 0 {
+			return intNumber(0), true
+		}
+		r = x * y
+		if r/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+			return NumberLiteral{}, false
+		}
+	case MOD:
+		if y == 0 {
+			return NumberLiteral{}, false
+		}
+		r = x % y
+	default:
+		return NumberLiteral{}, false
+	}
+	return intNumber(r), true
+}
+
+// negateNumber returns -n, exact for integers down to math.MinInt64.
+func negateNumber(n *NumberLiteral) NumberLiteral {
+	switch {
+	case n.Kind == NumberInt && n.Int != math.MinInt64:
+		return intNumber(-n.Int)
+	case n.Kind == NumberUint && n.Uint == 1<<63:
+		return intNumber(math.MinInt64)
+	}
+	return NumberLiteral{Val: -n.Val}
+}
@@ -1,42 +1,259 @@
 package conditions
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/scanner"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const maxArrayLen = 65536
 
+// notOperandPrecedence is the lowest precedence of the binary operators
+// which are part of the operand of NOT.
+var notOperandPrecedence = EQ.Precedence()
+
+// operandTokens lists the tokens an operand can start with.
+var operandTokens = []Token{LPAREN, NOT, EXISTS, SUB, NAME, IDENT, NUMBER, STRING, ARRAY, TIME, DURATION, TRUE, FALSE, NULL}
+
 // Parser encapsulates the scanner and responsible for returning AST
 // composed from statements read from a given reader.
 type Parser struct {
 	// Text scanner
 	s scanner.Scanner
+	// Source text, kept to annotate parse errors
+	src []byte
+	// Error met while reading the source
+	err error
+	// Whether syntax errors are collected instead of aborting the parsing
+	recovering bool
+	// Syntax errors collected while recovering
+	errs []*ParseError
+	// Nesting level of parentheses
+	depth int
+	// Functions which calls are bound to
+	functions *FunctionRegistry
 	// Buffer to keep the read forward token
 	buf struct {
-		tok rune   // last read token
-		tt  string // token text
-		n   int    // buffer size (max=1)
+		tok rune             // last read token
+		tt  string           // token text
+		pos scanner.Position // token position
+		err string           // scanner error met while reading the token
+		n   int              // buffer size (max=1)
+	}
+	// Buffer to keep the read forward mapped token
+	tokBuf struct {
+		tok Token            // last mapped token
+		lit string           // token literal
+		pos scanner.Position // token position
+		err string           // scanner error met while reading the token
+		n   int              // buffer size (max=1)
 	}
 }
 
 // NewParser returns a new instance of Parser.
 func NewParser(r io.Reader) *Parser {
 	p := &Parser{s: scanner.Scanner{}}
+	p.src, p.err = ioutil.ReadAll(r)
 	p.s.Mode = scanner.ScanIdents | scanner.ScanFloats | scanner.ScanStrings
-	p.s.Init(r)
+	p.s.Init(bytes.NewReader(p.src))
+	p.s.Error = func(_ *scanner.Scanner, msg string) {
+		p.buf.err = msg
+	}
 	return p
 }
 
+// SetFunctionRegistry makes the parser check that the called functions are
+// registered in r and accept the given number of arguments. The functions
+// are bound to the calls, so that the expression can be evaluated without
+// passing the registry again.
+func (p *Parser) SetFunctionRegistry(r *FunctionRegistry) {
+	p.functions = r
+}
+
 // Parse starts scanning & parsing process (main entry point).
 // It returns an expression (AST) which you can use for the final evaluation
-// of the conditions/statements
+// of the conditions/statements. Failures to parse are reported as *ParseError.
 func (p *Parser) Parse() (Expr, error) {
-	return p.parseExpr()
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	// The expression must span the whole input.
+	if tok, lit := p.scanWithMapping(); tok != EOF {
+		return nil, p.unexpected(tok, lit, EOF)
+	}
+
+	return expr, nil
+}
+
+// ParseAll parses the input like Parse but does not stop at the first
+// syntax error. Each error is recorded and the parser resynchronises at the
+// next logical operator (AND, OR, XOR, NAND), closing parenthesis or the end
+// of input. It returns the partial expression, where the parts that could not
+// be parsed are replaced by a BadExpr, along with every error found.
+func (p *Parser) ParseAll() (Expr, []*ParseError) {
+	if p.err != nil {
+		return nil, []*ParseError{{Message: p.err.Error()}}
+	}
+
+	p.recovering = true
+	expr, _ := p.parseExpr()
+
+	if tok, lit := p.scanWithMapping(); tok != EOF {
+		p.errs = append(p.errs, p.unexpected(tok, lit, EOF))
+	}
+
+	return expr, p.errs
+}
+
+// ParseError represents an error that occurred during parsing.
+type ParseError struct {
+	// Message describes the error. When empty the error is described by
+	// the found and expected tokens.
+	Message string
+	// Pos holds the offset, line and column of the offending token.
+	Pos scanner.Position
+	// Token is the offending token and Lit its literal text.
+	Token Token
+	Lit   string
+	// Expected lists the tokens which would have been accepted instead.
+	Expected []Token
+	// Snippet is the source line of the offending token followed by a line
+	// with a caret pointing at it.
+	Snippet string
+}
+
+// Error returns the string representation of the error.
+func (e *ParseError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		expected := make([]string, len(e.Expected))
+		for i, tok := range e.Expected {
+			expected[i] = tok.String()
+		}
+		msg = fmt.Sprintf("found %s, expected %s", tokstr(e.Token, e.Lit), strings.Join(expected, ", "))
+	}
+	return fmt.Sprintf("%s at line %d, column %d", msg, e.Pos.Line, e.Pos.Column)
+}
+
+// errorf returns a ParseError located at the last scanned token.
+func (p *Parser) errorf(format string, a ...interface{}) *ParseError {
+	err := p.unexpected(p.tokBuf.tok, p.tokBuf.lit)
+	err.Message = fmt.Sprintf(format, a...)
+	return err
+}
+
+// unexpected returns a ParseError reporting the last scanned token
+// where one of the expected tokens should have been.
+func (p *Parser) unexpected(tok Token, lit string, expected ...Token) *ParseError {
+	return p.unexpectedAt(p.tokBuf.pos, tok, lit, expected...)
+}
+
+// unexpectedAt is like unexpected for a token found at pos.
+func (p *Parser) unexpectedAt(pos scanner.Position, tok Token, lit string, expected ...Token) *ParseError {
+	err := &ParseError{
+		Pos:      pos,
+		Token:    tok,
+		Lit:      lit,
+		Expected: expected,
+		Snippet:  snippet(p.src, pos),
+	}
+	if tok == ILLEGAL {
+		err.Message = fmt.Sprintf("illegal token %s", lit)
+		if p.tokBuf.err != "" {
+			err.Message = p.tokBuf.err
+		}
+	}
+	return err
+}
+
+// fail reports err from the parsing of an operand. While recovering, err is
+// recorded, the input is skipped up to the next synchronisation point and a
+// BadExpr stands in for the operand.
+func (p *Parser) fail(err *ParseError) (Expr, error) {
+	if !p.recovering {
+		return nil, err
+	}
+
+	p.errs = append(p.errs, err)
+	// The offending token may itself be the synchronisation point.
+	p.unscanWithMapping()
+	p.synchronize()
+
+	return &BadExpr{Err: err}, nil
+}
+
+// synchronize skips tokens up to the next logical operator, unbalanced
+// closing parenthesis or the end of input, leaving that token unread.
+func (p *Parser) synchronize() {
+	depth := 0
+	for {
+		tok, _ := p.scanWithMapping()
+		switch tok {
+		case EOF:
+			p.unscanWithMapping()
+			return
+		case LPAREN:
+			depth++
+		case RPAREN:
+			if depth == 0 {
+				p.unscanWithMapping()
+				return
+			}
+			depth--
+		case AND, OR, XOR, NAND:
+			if depth == 0 {
+				p.unscanWithMapping()
+				return
+			}
+		case COMMA:
+			// Resume with the next argument of the enclosing call.
+			if depth == 0 && p.depth > 0 {
+				p.unscanWithMapping()
+				return
+			}
+		}
+	}
+}
+
+// snippet returns the line of src holding pos with a caret below pos.
+func snippet(src []byte, pos scanner.Position) string {
+	offset := pos.Offset
+	if offset > len(src) {
+		offset = len(src)
+	}
+
+	start := bytes.LastIndexByte(src[:offset], '\n') + 1
+	end := bytes.IndexByte(src[offset:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += offset
+	}
+
+	// Keep tabs so that the caret lines up with the source line.
+	indent := []rune(string(src[start:offset]))
+	for i, r := range indent {
+		if r != '\t' {
+			indent[i] = ' '
+		}
+	}
+
+	return string(src[start:end]) + "\n" + string(indent) + "^"
 }
 
 // scan returns the next token from the underlying scanner.
@@ -47,14 +264,35 @@ func (p *Parser) scan() (rune, string) {
 		p.buf.n = 0
 	} else {
 		// Otherwise read and put into buffer in case we 'unscan' it later
+		p.buf.err = ""
 		p.buf.tok, p.buf.tt = p.s.Scan(), p.s.TokenText()
+		p.buf.pos = p.s.Position
 	}
 	return p.buf.tok, p.buf.tt
 }
 
-// scanWithMapping uses scan with buffer (supports 'unscan') and maps
-// scanner's tokens to our custom tokens.
+// scanWithMapping returns the next mapped token. If a token has been
+// unscanned with unscanWithMapping then read that instead.
 func (p *Parser) scanWithMapping() (Token, string) {
+	if p.tokBuf.n != 0 {
+		p.tokBuf.n = 0
+	} else {
+		p.tokBuf.tok, p.tokBuf.lit, p.tokBuf.pos, p.tokBuf.err = p.scanToken()
+	}
+	return p.tokBuf.tok, p.tokBuf.lit
+}
+
+// unscanWithMapping pushes the previously mapped token back onto the buffer.
+// Unlike unscan it restores tokens composed of several scanner tokens,
+// such as ">=" or "NOT IN".
+func (p *Parser) unscanWithMapping() {
+	p.tokBuf.n = 1
+}
+
+// scanToken uses scan with buffer (supports 'unscan') and maps
+// scanner's tokens to our custom tokens. Along with the token it returns
+// its position and the error reported by the scanner while reading it.
+func (p *Parser) scanToken() (Token, string, scanner.Position, string) {
 	var (
 		t   rune
 		tok Token
@@ -62,6 +300,7 @@ func (p *Parser) scanWithMapping() (Token, string) {
 	)
 
 	t, tt = p.scan()
+	pos, scanErr := p.buf.pos, p.buf.err
 
 	// Map Go's token to our Token
 	switch t {
@@ -71,17 +310,35 @@ func (p *Parser) scanWithMapping() (Token, string) {
 		tok = LPAREN
 	case ')':
 		tok = RPAREN
+	case ',':
+		tok = COMMA
+	case '+':
+		tok = ADD
 	case '-':
-		t, tt = p.scan()
-
-		if t == scanner.Float || t == scanner.Int {
-			tok = NUMBER
-			tt = "-" + tt
-		} else {
-			tok = ILLEGAL
-		}
+		tok = SUB
+	case '*':
+		tok = MUL
+	case '/':
+		// A regular expression in place of an operand, see parseUnaryExpr.
+		tok = DIV
+	case '%':
+		tok = MOD
 	case scanner.Float, scanner.Int:
 		tok = NUMBER
+
+		// Dates such as 2024-01-01 or 2024-01-01T00:00:00Z start with a
+		// year immediately followed by the month and day, anything else
+		// after a dash like 2024-1 is a subtraction. Durations such as 15m
+		// or 1h30m start with a number immediately followed by a unit, a
+		// number followed by any other word like 5AND is left alone.
+		if t == scanner.Int && len(tt) == 4 && p.atMonthAndDay() {
+			tok = TIME
+			tt += p.scanTimeRest()
+		} else if p.atDurationUnit() {
+			_, unit := p.scan()
+			tok = DURATION
+			tt += unit
+		}
 	case '$':
 		t, tt = p.scan()
 
@@ -117,8 +374,13 @@ func (p *Parser) scanWithMapping() (Token, string) {
 		} else if t == '~' {
 			tok = NEREG
 			tt = "!~"
+		} else if t == '*' {
+			tok = INEQ
+			tt = "!*"
 		} else {
-			tok = ILLEGAL
+			tok = NOT
+			tt = "!"
+			p.unscan()
 		}
 	case '>':
 		t, tt = p.scan()
@@ -151,21 +413,13 @@ func (p *Parser) scanWithMapping() (Token, string) {
 		} else if t == '~' {
 			tok = EREG
 			tt = "=~"
+		} else if t == '*' {
+			tok = IEQ
+			tt = "=*"
 		} else {
 			tok = ILLEGAL
 		}
 
-	case '/':
-		var ttTmp string
-		for {
-			t, ttTmp = p.scan()
-			tt = tt + ttTmp
-			if t == '/' {
-				tok = STRING
-				break
-			}
-		}
-
 	case scanner.String:
 		tok = STRING
 	case scanner.Ident:
@@ -181,30 +435,101 @@ func (p *Parser) scanWithMapping() (Token, string) {
 			tok = NAND
 		} else if ttU == "IN" {
 			tok = IN
+		} else if ttU == "IIN" {
+			tok = IIN
 		} else if ttU == "NOT" {
 			_, tmp := p.scan()
-			if strings.ToUpper(tmp) == "IN" {
+			tmpU := strings.ToUpper(tmp)
+			if tmpU == "IN" {
 				tok = NOTIN
 				tt = "NOT IN"
-			} else if strings.ToUpper(tmp) == "CONTAINS" {
+			} else if tmpU == "IIN" {
+				tok = NOTIIN
+				tt = "NOT IIN"
+			} else if tmpU == "CONTAINS" {
 				tok = NOTCONTAINS
 				tt = "NOT CONTAINS"
+			} else if tmpU == "BETWEEN" {
+				tok = NOTBETWEEN
+				tt = "NOT BETWEEN"
+			} else if tmpU == "LIKE" {
+				tok = NOTLIKE
+				tt = "NOT LIKE"
+			} else if tmpU == "ILIKE" {
+				tok = NOTILIKE
+				tt = "NOT ILIKE"
+			} else if tmpU == "MATCHES" {
+				tok = NOTMATCHES
+				tt = "NOT MATCHES"
+			} else if tmpU == "STARTS" || tmpU == "ENDS" {
+				tok = NOTSTARTSWITH
+				if tmpU == "ENDS" {
+					tok = NOTENDSWITH
+				}
+				if p.scanWith() {
+					tt = tok.String()
+				} else {
+					// Only one token can be pushed back, so the
+					// NOT cannot be read as a negation anymore.
+					tok = ILLEGAL
+					tt = "NOT " + tmp
+				}
+			} else {
+				p.unscan()
+				tok = NOT
+			}
+		} else if ttU == "IS" {
+			tok = IS
+			if _, tmp := p.scan(); strings.ToUpper(tmp) == "NOT" {
+				tok = ISNOT
+				tt = "IS NOT"
 			} else {
 				p.unscan()
-				tok = ILLEGAL
 			}
+		} else if ttU == "EXISTS" {
+			tok = EXISTS
+		} else if ttU == "NULL" {
+			tok = NULL
 		} else if ttU == "TRUE" {
 			tok = TRUE
 		} else if ttU == "FALSE" {
 			tok = FALSE
 		} else if ttU == "CONTAINS" {
 			tok = CONTAINS
+		} else if ttU == "BETWEEN" {
+			tok = BETWEEN
+		} else if ttU == "LIKE" {
+			tok = LIKE
+		} else if ttU == "ILIKE" {
+			tok = ILIKE
+		} else if ttU == "MATCHES" {
+			tok = MATCHES
+		} else if (ttU == "STARTS" || ttU == "ENDS") && p.scanWith() {
+			tok = STARTSWITH
+			if ttU == "ENDS" {
+				tok = ENDSWITH
+			}
+			tt = tok.String()
 		} else {
-			tok = ILLEGAL
+			tok = NAME
 		}
 	}
 
-	return tok, tt
+	if scanErr != "" {
+		tok = ILLEGAL
+	}
+
+	return tok, tt, pos, scanErr
+}
+
+// scanWith reads the WITH following STARTS or ENDS. If the next token is
+// not WITH it is pushed back.
+func (p *Parser) scanWith() bool {
+	if _, tmp := p.scan(); strings.ToUpper(tmp) == "WITH" {
+		return true
+	}
+	p.unscan()
+	return false
 }
 
 // unscan pushes the previously read token back onto the buffer.
@@ -214,44 +539,81 @@ func (p *Parser) unscan() {
 
 // parseExpr is an entry point to parsing
 func (p *Parser) parseExpr() (Expr, error) {
-	// Parse a non-binary expression type to start.
-	// This variable will always be the root of the expression tree.
+	return p.parseBinaryExpr(lowestPrecedence)
+}
+
+// parseBinaryExpr parses a sequence of unary expressions joined by binary
+// operators using precedence climbing. Only operators binding at least as
+// tightly as minPrec are consumed, the rest are left for the caller.
+// Operators of equal precedence are left-associative, so "a OR b XOR c"
+// is parsed as "(a OR b) XOR c".
+func (p *Parser) parseBinaryExpr(minPrec int) (Expr, error) {
 	expr, err := p.parseUnaryExpr()
 	if err != nil {
 		return nil, err
 	}
 
-	// Loop over operations and unary exprs and build a tree based on precendence.
 	for {
-		// If the next token is NOT an operator then return the expression.
+		// If the next token is NOT an operator of high enough precedence
+		// then return the expression built so far.
 		op, tx := p.scanWithMapping()
 		if op == ILLEGAL {
-			return nil, fmt.Errorf("ILLEGAL %s", tx)
+			if !p.recovering {
+				return nil, p.unexpected(op, tx)
+			}
+			p.errs = append(p.errs, p.unexpected(op, tx))
+			p.synchronize()
+			continue
 		}
-		if !op.isOperator() {
-			p.unscan()
+		if p.recovering && !op.isOperator() && op != EOF && (op != RPAREN && op != COMMA || p.depth == 0) {
+			// Report the stray token and resume with the operators after it.
+			if op == RPAREN {
+				p.errs = append(p.errs, p.unexpected(op, tx, EOF))
+			} else {
+				p.errs = append(p.errs, p.errorf("found %s, expected operator", tokstr(op, tx)))
+				p.synchronize()
+			}
+			continue
+		}
+		if !op.isOperator() || op.Precedence() < minPrec {
+			p.unscanWithMapping()
 			return expr, nil
-
 		}
 
-		// Otherwise parse the next unary expression.
-		rhs, err := p.parseUnaryExpr()
-		if err != nil {
-			return nil, err
+		if op == BETWEEN || op == NOTBETWEEN {
+			if expr, err = p.parseBetween(expr, op); err != nil {
+				return nil, err
+			}
+			continue
 		}
 
-		// Assign the new root based on the precendence of the LHS and RHS operators.
-		if lhs, ok := expr.(*BinaryExpr); ok && lhs.Op.Precedence() <= op.Precedence() {
-			expr = &BinaryExpr{
-				LHS: lhs.LHS,
-				RHS: &BinaryExpr{LHS: lhs.RHS, RHS: rhs, Op: op},
-				Op:  lhs.Op,
+		if op == IS || op == ISNOT {
+			// NULL is the only operand IS compares with.
+			var rhs Expr = &NullLiteral{}
+			if tok, lit := p.scanWithMapping(); tok != NULL {
+				if rhs, err = p.fail(p.unexpected(tok, lit, NULL)); err != nil {
+					return nil, err
+				}
 			}
-		} else {
 			expr = &BinaryExpr{LHS: expr, RHS: rhs, Op: op}
+			continue
+		}
+
+		if op == EREG || op == NEREG || isPatternOperator(op) {
+			if expr, err = p.parseMatch(expr, op); err != nil {
+				return nil, err
+			}
+			continue
 		}
-	}
 
+		// Everything binding tighter than op belongs to its right operand.
+		rhs, err := p.parseBinaryExpr(op.Precedence() + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		expr = &BinaryExpr{LHS: expr, RHS: rhs, Op: op}
+	}
 }
 
 // parseUnaryExpr parses an non-binary expression.
@@ -259,14 +621,20 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 	// If the first token is a LPAREN then parse it as its own grouped expression.
 	tok, lit := p.scanWithMapping()
 	if tok == LPAREN {
+		p.depth++
 		expr, err := p.parseExpr()
+		p.depth--
 		if err != nil {
 			return nil, err
 		}
 
 		// Expect an RPAREN at the end.
-		if tok, _ := p.scanWithMapping(); tok != RPAREN {
-			return nil, fmt.Errorf("Missing )")
+		if tok, lit := p.scanWithMapping(); tok != RPAREN {
+			if !p.recovering {
+				return nil, p.unexpected(tok, lit, RPAREN)
+			}
+			p.errs = append(p.errs, p.unexpected(tok, lit, RPAREN))
+			p.unscanWithMapping()
 		}
 
 		return &ParenExpr{Expr: expr}, nil
@@ -274,23 +642,85 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 
 	// Read next token.
 	switch tok {
+	case NOT:
+		// NOT binds looser than comparisons, so "NOT {a} == 1" negates
+		// the whole comparison.
+		expr, err := p.parseBinaryExpr(notOperandPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: tok, Expr: expr}, nil
+	case EXISTS:
+		// EXISTS checks whether a variable is set, so only a variable
+		// can follow it.
+		tok, lit := p.scanWithMapping()
+		if tok != IDENT {
+			return p.fail(p.unexpected(tok, lit, IDENT))
+		}
+		return &UnaryExpr{Op: EXISTS, Expr: &VarRef{Val: lit}}, nil
+	case SUB:
+		// Negative numbers are literals, anything else is negated at evaluation.
+		if tok, lit := p.scanWithMapping(); tok == NUMBER {
+			v, err := parseNumber("-" + lit)
+			if err != nil {
+				return p.fail(p.errorf("unable to parse number -%s", lit))
+			}
+			return &v, nil
+		}
+		p.unscanWithMapping()
+
+		expr, err := p.parseUnaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: tok, Expr: expr}, nil
+	case DIV:
+		// A slash opening an operand starts a regular expression.
+		re, err := p.scanRegex()
+		if err != nil {
+			return p.fail(err)
+		}
+		return &StringLiteral{Val: re}, nil
+	case NAME:
+		return p.parseCall(lit)
 	case IDENT:
 		return &VarRef{Val: lit}, nil
 	case STRING:
-		return &StringLiteral{Val: lit[1 : len(lit)-1]}, nil
+		// Strings are unescaped like Go strings, the reverse of Quote.
+		s, err := strconv.Unquote(lit)
+		if err != nil {
+			return p.fail(p.errorf("invalid string %s", lit))
+		}
+		return &StringLiteral{Val: s}, nil
 	case NUMBER:
-		v, err := strconv.ParseFloat(lit, 64)
+		v, err := parseNumber(lit)
+		if err != nil {
+			return p.fail(p.errorf("unable to parse number %s", lit))
+		}
+		return &v, nil
+	case TIME:
+		v, err := parseTime(lit)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to parse number")
+			return p.fail(p.errorf("invalid time %s", lit))
 		}
-		return &NumberLiteral{Val: v}, nil
+		return &TimeLiteral{Val: v}, nil
+	case DURATION:
+		v, err := ParseDuration(lit)
+		if err != nil {
+			return p.fail(p.errorf("invalid duration %s", lit))
+		}
+		return &DurationLiteral{Val: v}, nil
 	case TRUE, FALSE:
 		return &BooleanLiteral{Val: (tok == TRUE)}, nil
+	case NULL:
+		return &NullLiteral{}, nil
 	case ARRAY:
-		mapVal := []interface{}{}
-		err := json.Unmarshal([]byte(`[`+lit+`]`), &mapVal)
+		mapVal, err := decodeArray(lit)
+		if err != nil {
+			return p.fail(p.errorf("invalid array [%s]: %s", lit, err))
+		}
 		if len(mapVal) == 0 {
-			return nil, fmt.Errorf("Empty Slice not castable")
+			return p.fail(p.errorf("empty array is not castable"))
 		}
 		switch t := mapVal[0].(type) {
 		case string:
@@ -298,29 +728,277 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 			for _, v := range mapVal {
 				str, ok := v.(string)
 				if !ok {
-					return nil, fmt.Errorf("the items in the array are not all string")
+					return p.fail(p.errorf("the items in the array are not all string"))
 				}
 				values = append(values, str)
 			}
-			ssl := NewSliceStringLiteral(values)
-			return ssl, err
-		case float64:
-			values := []float64{}
+			return NewSliceStringLiteral(values), nil
+		case json.Number:
+			values := make([]NumberLiteral, 0, len(mapVal))
 			for _, v := range mapVal {
-				f, ok := v.(float64)
+				num, ok := v.(json.Number)
 				if !ok {
-					return nil, fmt.Errorf("the items in the array are not all number")
+					return p.fail(p.errorf("the items in the array are not all number"))
 				}
-				values = append(values, f)
+				n, err := parseNumber(string(num))
+				if err != nil {
+					return p.fail(p.errorf("unable to parse number %s", num))
+				}
+				values = append(values, n)
 			}
-			return &SliceNumberLiteral{Val: values}, err
+			return newSliceNumberLiteral(values), nil
 		default:
-			return nil, fmt.Errorf("Slice of unknow type %s %T", t, t)
+			return p.fail(p.errorf("array of unknown type %T", t))
 		}
 
 	default:
-		return nil, fmt.Errorf("Parsing error: tok=%v, lit=%v", tok, lit)
+		return p.fail(p.unexpected(tok, lit, operandTokens...))
+	}
+}
+
+// decodeArray decodes the items of an array, keeping numbers as
+// json.Number for integers to stay exact.
+func decodeArray(lit string) ([]interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(`[` + lit + `]`))
+	dec.UseNumber()
+	var items []interface{}
+	if err := dec.Decode(&items); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the array")
+	}
+	return items, nil
+}
+
+// parseBetween parses the bounds of a BETWEEN operation on expr. The
+// operator has already been read.
+func (p *Parser) parseBetween(expr Expr, op Token) (Expr, error) {
+	// The bounds bind tighter than the comparisons, so the AND
+	// separating them is not taken for a logical operator.
+	lower, err := p.parseBinaryExpr(op.Precedence() + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var upper Expr
+	if tok, lit := p.scanWithMapping(); tok == AND {
+		if upper, err = p.parseBinaryExpr(op.Precedence() + 1); err != nil {
+			return nil, err
+		}
+	} else if upper, err = p.fail(p.unexpected(tok, lit, AND)); err != nil {
+		return nil, err
+	}
+
+	return &BetweenExpr{Op: op, Expr: expr, Lower: lower, Upper: upper}, nil
+}
+
+// parseMatch parses the pattern of a =~, !~, LIKE, ILIKE or MATCHES
+// operation on expr. The operator has already been read. Literal patterns
+// are compiled, so that invalid ones are reported here and not on every
+// evaluation.
+func (p *Parser) parseMatch(expr Expr, op Token) (Expr, error) {
+	tok, lit := p.scanWithMapping()
+	pos := p.tokBuf.pos
+	p.unscanWithMapping()
+
+	rhs, err := p.parseBinaryExpr(op.Precedence() + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := rhs.(*StringLiteral); ok {
+		if !isPatternOperator(op) {
+			if re, reErr := regexp.Compile(s.Val); reErr == nil {
+				rhs = &RegexLiteral{Val: re}
+			} else if rhs, err = p.failAt(p.unexpectedAt(pos, tok, lit), "%s", reErr); err != nil {
+				return nil, err
+			}
+		} else if re, reErr := regexp.Compile(patternToRegexp(op, s.Val)); reErr == nil {
+			rhs = &RegexLiteral{Val: re, pattern: s}
+		} else if rhs, err = p.failAt(p.unexpectedAt(pos, tok, lit), "invalid pattern %q: %s", s.Val, reErr); err != nil {
+			return nil, err
+		}
+	}
+
+	return &BinaryExpr{LHS: expr, RHS: rhs, Op: op}, nil
+}
+
+// parseCall parses the arguments of a call to the named function.
+// The name has already been read.
+func (p *Parser) parseCall(name string) (Expr, error) {
+	pos := p.tokBuf.pos
+	if tok, _ := p.scanWithMapping(); tok != LPAREN {
+		// A bare word is not a valid operand.
+		p.unscanWithMapping()
+		return p.fail(p.unexpectedAt(pos, NAME, name, operandTokens...))
+	}
+
+	call := &CallExpr{Name: name}
+
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if tok, _ := p.scanWithMapping(); tok != RPAREN {
+		p.unscanWithMapping()
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			call.Arguments = append(call.Arguments, arg)
+
+			tok, lit := p.scanWithMapping()
+			if tok == RPAREN {
+				break
+			}
+			if tok != COMMA {
+				if !p.recovering {
+					return nil, p.unexpected(tok, lit, COMMA, RPAREN)
+				}
+				p.errs = append(p.errs, p.unexpected(tok, lit, COMMA, RPAREN))
+				p.unscanWithMapping()
+				break
+			}
+		}
+	}
+
+	if p.functions != nil {
+		fn, ok := p.functions.Lookup(name)
+		if !ok {
+			return p.failAt(p.unexpectedAt(pos, NAME, name), "unknown function %s", name)
+		}
+		if err := fn.CheckArity(len(call.Arguments)); err != nil {
+			return p.failAt(p.unexpectedAt(pos, NAME, name), "%s", err)
+		}
+		call.fn = fn
+	}
+
+	return call, nil
+}
+
+// failAt reports err with the given message. Unlike fail, no input is
+// skipped while recovering.
+func (p *Parser) failAt(err *ParseError, format string, a ...interface{}) (Expr, error) {
+	err.Message = fmt.Sprintf(format, a...)
+	if !p.recovering {
+		return nil, err
+	}
+	p.errs = append(p.errs, err)
+	return &BadExpr{Err: err}, nil
+}
+
+// atMonthAndDay reports whether the source following the scanned token is
+// the -MM-DD of a date.
+func (p *Parser) atMonthAndDay() bool {
+	rest := p.src[p.s.Pos().Offset:]
+	if len(rest) < 6 {
+		return false
+	}
+	for i, ch := range rest[:6] {
+		if i == 0 || i == 3 {
+			if ch != '-' {
+				return false
+			}
+		} else if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// atDurationUnit reports whether the word following the scanned number
+// is made of duration units, such as the h30m of 1h30m.
+func (p *Parser) atDurationUnit() bool {
+	rest := p.src[p.s.Pos().Offset:]
+	if ch, _ := utf8.DecodeRune(rest); !unicode.IsLetter(ch) {
+		return false
+	}
+	end := bytes.IndexFunc(rest, func(ch rune) bool {
+		return !unicode.IsLetter(ch) && !unicode.IsDigit(ch) && ch != '_'
+	})
+	if end < 0 {
+		end = len(rest)
+	}
+	_, err := ParseDuration("0" + string(rest[:end]))
+	return err == nil
+}
+
+// scanTimeRest reads the rest of a time literal following its year.
+func (p *Parser) scanTimeRest() string {
+	var rest []rune
+	for {
+		ch := p.s.Peek()
+		if !unicode.IsDigit(ch) && !unicode.IsLetter(ch) && !strings.ContainsRune("-:.+", ch) {
+			return string(rest)
+		}
+		rest = append(rest, p.s.Next())
+	}
+}
+
+// timeLayouts lists the accepted layouts of time literals. Times without
+// a zone are in UTC.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"}
+
+// parseTime parses a time literal.
+func parseTime(s string) (time.Time, error) {
+	var err error
+	for _, layout := range timeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// scanRegex reads the source following an opening slash up to the closing
+// one, followed by optional flags. The flags i, m, s and U are those of
+// RE2 and are prepended to the expression as a (?flags) group.
+func (p *Parser) scanRegex() (string, *ParseError) {
+	var re []rune
+
+	for {
+		ch := p.s.Next()
+		switch ch {
+		case scanner.EOF:
+			return "", p.errorf("regular expression not terminated")
+		case '/':
+			return p.scanRegexFlags(string(re))
+		case '\\':
+			// An escaped slash is part of the expression. Any other
+			// escaped character, a backslash as well, is kept escaped.
+			switch p.s.Peek() {
+			case scanner.EOF:
+			case '/':
+				re = append(re, p.s.Next())
+				continue
+			default:
+				re = append(re, ch, p.s.Next())
+				continue
+			}
+		}
+		re = append(re, ch)
+	}
+}
+
+// scanRegexFlags reads the flags following the closing slash of the
+// regular expression re.
+func (p *Parser) scanRegexFlags(re string) (string, *ParseError) {
+	var flags []rune
+	for unicode.IsLetter(p.s.Peek()) {
+		ch := p.s.Next()
+		if !strings.ContainsRune("imsU", ch) {
+			return "", p.errorf("unknown regular expression flag %q", ch)
+		}
+		if !strings.ContainsRune(string(flags), ch) {
+			flags = append(flags, ch)
+		}
+	}
+	if len(flags) == 0 {
+		return re, nil
 	}
+	return "(?" + string(flags) + ")" + re, nil
 }
 
 func (p *Parser) scanArray(tt string) (rune, string, error) {
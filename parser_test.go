@@ -3,8 +3,11 @@ package conditions
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"math/rand"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -16,7 +19,27 @@ var invalidTestData = []string{
 	"{var0} == CA",
 	"{var0} == PA",
 	"{var0} == 'DEMO'",
-	"!{var0}",
+	"!",
+	"NOT",
+	"{var0} AND NOT",
+	"{var0} =~ /unterminated",
+	`{var0} =~ /a\\\/`,
+	"{var0} =~ /x/ix",
+	"{at} > 2024-13-01",
+	"{at} > 2024-01-01T25:00:00Z",
+	"{timeout} > 15y",
+	"{timeout} > 15m30",
+	"{host} STARTS \"api\"",
+	"{host} NOT STARTS \"api\"",
+	"{host} NOT ENDS",
+	"{host} ENDS WITH",
+	"{host} LIKE",
+	"{a} IS 1",
+	"{a} IS NOT",
+	"EXISTS 1",
+	"EXISTS lower({a})",
+	"{var0} * ",
+	"* {var0}",
 	"{var0} <> `DEMO`",
 	"{foo} in []",
 	"{foo} in [foobar]",
@@ -30,6 +53,10 @@ var invalidTestData = []string{
 	"{foo} in [\"3\", 2, 1]",
 	"{foo} in [\"3\", 2, 1",
 	"{foo} not in [foobar]",
+	"{var0} == 1 )",
+	"({var0} == 1",
+	"{var0} == 1 {var1}",
+	"{var0} == \"unterminated",
 }
 
 func TestInvalid(t *testing.T) {
@@ -56,12 +83,16 @@ func TestInvalid(t *testing.T) {
 	}
 }
 
-var validTestData = []struct {
+// evalTest is a condition evaluated with args, and the expected result or
+// whether the evaluation fails.
+type evalTest struct {
 	cond   string
 	args   map[string]interface{}
 	result bool
 	isErr  bool
-}{
+}
+
+var validTestData = []evalTest{
 	{"true", nil, true, false},
 	{"false", nil, false, false},
 	{"false OR true OR false OR false OR true", nil, true, false},
@@ -87,6 +118,16 @@ var validTestData = []struct {
 	{"\"ON\"", nil, false, true},
 	{"{var0} == \"OFF\"", map[string]interface{}{"var0": "OFF"}, true, false},
 
+	// NOT
+	{"!{var0}", map[string]interface{}{"var0": false}, true, false},
+	{"NOT {var0}", map[string]interface{}{"var0": true}, false, false},
+	{"not not {var0}", map[string]interface{}{"var0": true}, true, false},
+	{"NOT ({var0} AND {var1})", map[string]interface{}{"var0": true, "var1": false}, true, false},
+	{"NOT ({var0} AND {var1})", map[string]interface{}{"var0": true, "var1": true}, false, false},
+	{"!({var0} OR {var1}) AND true", map[string]interface{}{"var0": false, "var1": false}, true, false},
+	{"NOT {var0} == \"OFF\" AND {var1}", map[string]interface{}{"var0": "ON", "var1": true}, true, false},
+	{"NOT {var0}", map[string]interface{}{"var0": 1}, false, true},
+
 	// AND
 	{"{var0} > 10 AND {var1} == \"OFF\"", map[string]interface{}{"var0": 14, "var1": "OFF"}, true, false},
 	{"({var0} > 10) AND ({var1} == \"OFF\")", map[string]interface{}{"var0": 14, "var1": "OFF"}, true, false},
@@ -98,7 +139,8 @@ var validTestData = []struct {
 	{"{foo} == 123", map[string]interface{}{"foo": json.Number("123"), "bar": true}, true, false},
 
 	// OR
-	{"{foo} == true OR {foo} > 1", map[string]interface{}{"foo": true}, false, true},
+	{"{foo} == true OR {foo} > 1", map[string]interface{}{"foo": true}, true, false},
+	{"{foo} == false OR {foo} > 1", map[string]interface{}{"foo": true}, false, true},
 	{"{foo} == true OR {foo} == false", map[string]interface{}{"foo": true}, true, false},
 	{"{foo} > 100 OR {foo} < 99 ", map[string]interface{}{"foo": 100}, false, false},
 	{"{foo}{dfs} == true or {bar} == true", map[string]interface{}{"foo.dfs": true, "bar": true}, true, false},
@@ -174,10 +216,50 @@ var validTestData = []struct {
 	{`{status} =~ "foo"`, map[string]interface{}{"status": "foobar"}, true, false},
 	{`{status} =~ "foo"`, map[string]interface{}{"status": "bar"}, false, false},
 
+	{`{status} =~ /foo bar/`, map[string]interface{}{"status": "a foo bar"}, true, false},
+	{`{path} =~ /^\/api\//`, map[string]interface{}{"path": "/api/v1"}, true, false},
+	{`{path} =~ /a\\/`, map[string]interface{}{"path": `a\`}, true, false},
+	{`{path} =~ /a\\/ AND {path} =~ /\\\//`, map[string]interface{}{"path": `a\/`}, true, false},
+
 	//{!~
 	{"{status} !~ /^5\\d\\d/", map[string]interface{}{"status": "500"}, false, false},
 	{"{status} !~ /^4\\d\\d/", map[string]interface{}{"status": "500"}, true, false},
 
+	// Arithmetic
+	{"{price} * {qty} > 1000", map[string]interface{}{"price": 250.5, "qty": 4}, true, false},
+	{"{price} * {qty} > 1000", map[string]interface{}{"price": 250, "qty": 4}, false, false},
+	{"{used} / {quota} >= 0.9", map[string]interface{}{"used": 90, "quota": 100}, true, false},
+	{"{used} / {quota} >= 0.9", map[string]interface{}{"used": 90, "quota": 0}, false, true},
+	{"{a} % {b} == 1", map[string]interface{}{"a": 7, "b": 3}, true, false},
+	{"{a} % 0 == 1", map[string]interface{}{"a": 7}, false, true},
+	{"1 + 2 * 3 == 7", nil, true, false},
+	{"(1 + 2) * 3 == 9", nil, true, false},
+	{"10 - 4 - 3 == 3", nil, true, false},
+	{"2 * 6 / 3 == 4", nil, true, false},
+	{"-{a} == -5", map[string]interface{}{"a": 5}, true, false},
+	{"-{a} * -2 == 10", map[string]interface{}{"a": 5}, true, false},
+	{"{a}-5 == 0", map[string]interface{}{"a": 5}, true, false},
+	{"- -5 == 5", nil, true, false},
+	{"{a} + 1 == 2", map[string]interface{}{"a": "1"}, false, true},
+	{"-{a}", map[string]interface{}{"a": true}, false, true},
+
+	// Time and duration
+	{"{at} > 2024-01-01T00:00:00Z", map[string]interface{}{"at": time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, true, false},
+	{"{at} < 2024-01-01T00:00:00Z", map[string]interface{}{"at": time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, false, false},
+	{"{at} == 2024-01-01T02:00:00+02:00", map[string]interface{}{"at": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, true, false},
+	{"{at} >= 2024-01-01 AND {at} < 2024-01-02", map[string]interface{}{"at": time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}, true, false},
+	{"{at} <= 2024-01-01T12:00:00.5", map[string]interface{}{"at": time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}, true, false},
+	{"{at} > 2024-01-01", map[string]interface{}{"at": "2024-03-01"}, false, true},
+	{"{at} > 15m", map[string]interface{}{"at": time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, false, true},
+	{"{timeout} > 15m", map[string]interface{}{"timeout": 20 * time.Minute}, true, false},
+	{"{timeout} <= 1h30m", map[string]interface{}{"timeout": 90 * time.Minute}, true, false},
+	{"{timeout} == 1.5h", map[string]interface{}{"timeout": 90 * time.Minute}, true, false},
+	{"{timeout} >= 7d", map[string]interface{}{"timeout": 7 * 24 * time.Hour}, true, false},
+	{"{timeout} < 1w", map[string]interface{}{"timeout": 7 * 24 * time.Hour}, false, false},
+	{"{timeout} != 250ms", map[string]interface{}{"timeout": 250 * time.Millisecond}, false, false},
+	{"-{timeout} < 0s", map[string]interface{}{"timeout": time.Second}, true, false},
+	{"{timeout} > 15", map[string]interface{}{"timeout": 20 * time.Minute}, false, true},
+
 	// number collection
 
 	{"54 IN {numbers}", map[string]interface{}{
@@ -201,6 +283,218 @@ var validTestData = []struct {
 	{"{foo.laz.1.bar} == 20", map[string]interface{}{
 		"foo.laz.1.bar": 20,
 	}, true, false},
+
+	// []interface{} arguments
+
+	{"2 IN {list}", map[string]interface{}{"list": []interface{}{1, 2}}, true, false},
+	{"{list} CONTAINS 3", map[string]interface{}{"list": []interface{}{uint8(1), int64(3), 2.5}}, true, false},
+	{"\"b\" IN {list}", map[string]interface{}{"list": []interface{}{"a", "b"}}, true, false},
+	{"\"a\" IN {list}", map[string]interface{}{"list": []interface{}{"a", 1}}, false, true},
+	{"1 IN {list}", map[string]interface{}{"list": []interface{}{1, "a"}}, false, true},
+	{"1 IN {list}", map[string]interface{}{"list": []interface{}{true}}, false, true},
+	{"0 IN {list}", map[string]interface{}{"list": []interface{}{json.Number("1"), json.Number("x")}}, false, true},
+	{"0 IN {list}", map[string]interface{}{"list": []json.Number{"x"}}, false, true},
+	{"{list} CONTAINS 2", map[string]interface{}{"list": []json.Number{"1", "2"}}, true, false},
+
+	// BETWEEN
+
+	{`{x} BETWEEN 1 AND 10`, map[string]interface{}{"x": 5}, true, false},
+	{`{x} BETWEEN 1 AND 10`, map[string]interface{}{"x": 1}, true, false},
+	{`{x} BETWEEN 1 AND 10`, map[string]interface{}{"x": 10.0000001}, true, false},
+	{`{x} BETWEEN 1 AND 10`, map[string]interface{}{"x": 11}, false, false},
+	{`{x} BETWEEN 10 AND 1`, map[string]interface{}{"x": 5}, false, false},
+	{`{x} NOT BETWEEN 1 AND 10`, map[string]interface{}{"x": 0}, true, false},
+	{`{x} NOT BETWEEN 1 AND 10`, map[string]interface{}{"x": 10}, false, false},
+	{`{x} BETWEEN {lo} AND {lo} * 2 AND {y}`, map[string]interface{}{"x": 3, "lo": 2, "y": true}, true, false},
+	{`{name} BETWEEN "a" AND "m"`, map[string]interface{}{"name": "john"}, true, false},
+	{`{name} BETWEEN "a" AND "m"`, map[string]interface{}{"name": "zoe"}, false, false},
+	{`{t} BETWEEN 2024-01-01 AND 2024-12-31`, map[string]interface{}{"t": time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}, true, false},
+	{`{d} NOT BETWEEN 1m AND 1h`, map[string]interface{}{"d": 2 * time.Hour}, true, false},
+	{`{x} BETWEEN "a" AND 10`, map[string]interface{}{"x": 5}, false, true},
+	{`{x} BETWEEN 1 AND 10`, map[string]interface{}{"x": "5"}, false, true},
+
+	// string matching
+
+	{`{host} STARTS WITH "api."`, map[string]interface{}{"host": "api.example.com"}, true, false},
+	{`{host} starts with "api."`, map[string]interface{}{"host": "www.example.com"}, false, false},
+	{`{host} NOT STARTS WITH "api."`, map[string]interface{}{"host": "www.example.com"}, true, false},
+	{`{host} ENDS WITH ".com"`, map[string]interface{}{"host": "api.example.com"}, true, false},
+	{`{host} NOT ENDS WITH ".com"`, map[string]interface{}{"host": "api.example.com"}, false, false},
+	{`{host} ENDS WITH {suffix}`, map[string]interface{}{"host": "api.example.com", "suffix": "example.com"}, true, false},
+	{`{name} LIKE "Jo%"`, map[string]interface{}{"name": "John"}, true, false},
+	{`{name} LIKE "J_hn"`, map[string]interface{}{"name": "John"}, true, false},
+	{`{name} LIKE "J_hn"`, map[string]interface{}{"name": "Johan"}, false, false},
+	{`{name} LIKE "%oh%"`, map[string]interface{}{"name": "John"}, true, false},
+	{`{name} LIKE "jo%"`, map[string]interface{}{"name": "John"}, false, false},
+	{`{name} LIKE "J.*"`, map[string]interface{}{"name": "John"}, false, false},
+	{`{name} LIKE "%"`, map[string]interface{}{"name": "line\nbreak"}, true, false},
+	{`{name} NOT LIKE "%x%"`, map[string]interface{}{"name": "John"}, true, false},
+	{`{name} LIKE {pattern}`, map[string]interface{}{"name": "100%", "pattern": `1__\%`}, true, false},
+	{`{name} LIKE {pattern}`, map[string]interface{}{"name": "1000", "pattern": `1__\%`}, false, false},
+	{`{name} LIKE "1__\\%"`, map[string]interface{}{"name": "100%"}, true, false},
+	{`{name} LIKE "1__\\%"`, map[string]interface{}{"name": "1000"}, false, false},
+	{`{name} LIKE "%\\\\"`, map[string]interface{}{"name": `a\`}, true, false},
+	{`{host} MATCHES "*.example.com"`, map[string]interface{}{"host": "api.example.com"}, true, false},
+	{`{host} MATCHES "*.example.com"`, map[string]interface{}{"host": "example.com"}, false, false},
+	{`{host} MATCHES "*.example.com"`, map[string]interface{}{"host": "api.exampleXcom"}, false, false},
+	{`{host} MATCHES "api?.example.*"`, map[string]interface{}{"host": "api2.example.org"}, true, false},
+	{`{host} MATCHES "api[0-9].*"`, map[string]interface{}{"host": "api7.example.org"}, true, false},
+	{`{host} MATCHES "api[!0-9].*"`, map[string]interface{}{"host": "api7.example.org"}, false, false},
+	{`{host} MATCHES "api[.*"`, map[string]interface{}{"host": "api[.example.org"}, true, false},
+	{`{host} NOT MATCHES "*.example.com"`, map[string]interface{}{"host": "example.org"}, true, false},
+	{`{host} MATCHES {pattern}`, map[string]interface{}{"host": "a*b", "pattern": `a\*b`}, true, false},
+	{`{host} MATCHES {pattern}`, map[string]interface{}{"host": "axb", "pattern": `a\*b`}, false, false},
+	{`{host} MATCHES "a\\*b"`, map[string]interface{}{"host": "a*b"}, true, false},
+	{`{host} MATCHES "a\\*b"`, map[string]interface{}{"host": "axb"}, false, false},
+	{`{host} MATCHES {pattern}`, map[string]interface{}{"host": "api", "pattern": "api[z-a]"}, false, true},
+	{`{port} STARTS WITH "80"`, map[string]interface{}{"port": 8080}, false, true},
+	{`{port} NOT LIKE "80%"`, map[string]interface{}{"port": 8080}, false, true},
+
+	// case insensitive
+
+	{`{country} =* "de"`, map[string]interface{}{"country": "DE"}, true, false},
+	{`{country} =* "de"`, map[string]interface{}{"country": "AT"}, false, false},
+	{`{country} !* "de"`, map[string]interface{}{"country": "De"}, false, false},
+	{`{country} !* "de"`, map[string]interface{}{"country": "AT"}, true, false},
+	{`{name} =* "STRASSE"`, map[string]interface{}{"name": "strasse"}, true, false},
+	{`{name} =* "STRASSE"`, map[string]interface{}{"name": "straße"}, false, false},
+	{`{name} IIN ["STRASSE"]`, map[string]interface{}{"name": "straße"}, false, false},
+	{`{name} =* "ΣΊΣΥΦΟΣ"`, map[string]interface{}{"name": "σίσυφος"}, true, false},
+	{`{name} =* "K"`, map[string]interface{}{"name": "K"}, true, false},
+	{`{count} =* 2`, map[string]interface{}{"count": 2}, true, false},
+	{`{country} =* 2`, map[string]interface{}{"country": "DE"}, false, true},
+	{`{country} IIN ["de", "at", "ch"]`, map[string]interface{}{"country": "AT"}, true, false},
+	{`{country} IIN ["de", "at", "ch"]`, map[string]interface{}{"country": "FR"}, false, false},
+	{`{country} NOT IIN ["de", "at", "ch"]`, map[string]interface{}{"country": "Fr"}, true, false},
+	{`{country} IIN {allowed}`, map[string]interface{}{"country": "ch", "allowed": []string{"DE", "CH"}}, true, false},
+	{`{country} IIN {allowed}`, map[string]interface{}{"country": "ch", "allowed": TryNewCollection([]interface{}{"DE", "CH"})}, true, false},
+	{`{country} IIN {allowed}`, map[string]interface{}{"country": "fr", "allowed": TryNewCollection([]interface{}{"DE", "CH"})}, false, false},
+	{`{code} IIN [1, 2]`, map[string]interface{}{"code": 2}, true, false},
+	{`{country} IIN [1, 2]`, map[string]interface{}{"country": "DE"}, false, true},
+	{`{email} ILIKE "%@EXAMPLE.com"`, map[string]interface{}{"email": "john@example.COM"}, true, false},
+	{`{email} LIKE "%@EXAMPLE.com"`, map[string]interface{}{"email": "john@example.COM"}, false, false},
+	{`{email} NOT ILIKE "%@example.com"`, map[string]interface{}{"email": "john@example.org"}, true, false},
+	{`{email} =~ /@example\.com$/i`, map[string]interface{}{"email": "JOHN@EXAMPLE.COM"}, true, false},
+	{`{email} =~ /@example\.com$/`, map[string]interface{}{"email": "JOHN@EXAMPLE.COM"}, false, false},
+	{`{text} =~ /^a.b$/s`, map[string]interface{}{"text": "a\nb"}, true, false},
+	{`{text} =~ /^a.b$/`, map[string]interface{}{"text": "a\nb"}, false, false},
+	{`{text} =~ /^B$/mi`, map[string]interface{}{"text": "a\nb"}, true, false},
+	{`{text} !~ /^A/i`, map[string]interface{}{"text": "abc"}, false, false},
+	{`{text} !~ {pattern}`, map[string]interface{}{"text": "abc", "pattern": "("}, false, true},
+
+	// NULL
+
+	{`{a} IS NULL`, map[string]interface{}{}, true, false},
+	{`{a} IS NULL`, map[string]interface{}{"a": nil}, true, false},
+	{`{a} IS NULL`, map[string]interface{}{"a": 0}, false, false},
+	{`{a} is not null`, map[string]interface{}{}, false, false},
+	{`{a} IS NOT NULL`, map[string]interface{}{"a": nil}, false, false},
+	{`{a} IS NOT NULL`, map[string]interface{}{"a": ""}, true, false},
+	{`EXISTS {a}`, map[string]interface{}{}, false, false},
+	{`EXISTS {a}`, map[string]interface{}{"a": nil}, true, false},
+	{`EXISTS {a}{b}`, map[string]interface{}{"a.b": 1}, true, false},
+	{`NOT EXISTS {a}`, map[string]interface{}{}, true, false},
+	{`EXISTS {a} AND {a} IS NULL`, map[string]interface{}{"a": nil}, true, false},
+	{`{a} IS NULL OR {a} > 1`, map[string]interface{}{"a": 2}, true, false},
+	{`lower({a}) IS NOT NULL`, map[string]interface{}{"a": "X"}, true, false},
+	{`{a} == NULL`, map[string]interface{}{"a": nil}, true, false},
+	{`{a} == NULL`, map[string]interface{}{"a": 1}, false, false},
+	{`{a} != NULL`, map[string]interface{}{"a": "x"}, true, false},
+	{`NULL == NULL`, nil, true, false},
+	{`{a} == NULL`, map[string]interface{}{}, false, true},
+	{`{a} > 1`, map[string]interface{}{"a": nil}, false, false},
+	{`{a} <= "x"`, map[string]interface{}{"a": nil}, false, false},
+	{`{a} NOT STARTS WITH "x"`, map[string]interface{}{"a": nil}, false, false},
+	{`{a} NOT BETWEEN 1 AND 2`, map[string]interface{}{"a": nil}, false, false},
+	{`NOT {a} > 1`, map[string]interface{}{"a": nil}, true, false},
+	{`{a} IN [1, 2]`, map[string]interface{}{"a": nil}, false, true},
+	{`lower({a}) IS NULL`, map[string]interface{}{}, false, true},
+
+	// missing variables without options
+
+	{`{promo} == "X" OR {tier} == "gold"`, map[string]interface{}{"tier": "gold"}, false, true},
+
+	// slice and collection arguments
+
+	{`{tags} CONTAINS "b"`, map[string]interface{}{"tags": []string{"a", "b"}}, true, false},
+	{`{tags} NOT CONTAINS "b"`, map[string]interface{}{"tags": []string{"a", "c"}}, true, false},
+	{`"b" IN {tags}`, map[string]interface{}{"tags": []interface{}{"a", "b"}}, true, false},
+	{`{ids} CONTAINS 2`, map[string]interface{}{"ids": []int{1, 2}}, true, false},
+	{`{ids} CONTAINS 3`, map[string]interface{}{"ids": []int32{1, 2}}, false, false},
+	{`2 IN {ids}`, map[string]interface{}{"ids": []int64{1, 2}}, true, false},
+	{`{ids} CONTAINS 1.5`, map[string]interface{}{"ids": []float32{1.5}}, true, false},
+	{`{ids} CONTAINS 1.5`, map[string]interface{}{"ids": []float64{1.5}}, true, false},
+	{`len({ids}) == 2`, map[string]interface{}{"ids": []int{1, 2}}, true, false},
+	{`{tags} CONTAINS "b"`, map[string]interface{}{"tags": tagSet{"b": {}}}, true, false},
+	{`"c" IN {tags}`, map[string]interface{}{"tags": tagSet{"b": {}}}, false, false},
+	{`{tags} CONTAINS "b"`, map[string]interface{}{"tags": NewMapStringCollection([]interface{}{"b"})}, true, false},
+	{`{ids} CONTAINS 2`, map[string]interface{}{"ids": NewMapNumberCollection([]interface{}{2})}, true, false},
+
+	// strings without coercion
+
+	{`{a} == "123"`, map[string]interface{}{"a": 123}, false, true},
+
+	// numeric kinds and named types
+
+	{`{a} == -3`, map[string]interface{}{"a": int8(-3)}, true, false},
+	{`{a} == 300`, map[string]interface{}{"a": int16(300)}, true, false},
+	{`{a} == 7`, map[string]interface{}{"a": uint(7)}, true, false},
+	{`{a} == 255`, map[string]interface{}{"a": uint8(255)}, true, false},
+	{`{a} == 65535`, map[string]interface{}{"a": uint16(65535)}, true, false},
+	{`{a} == 4294967295`, map[string]interface{}{"a": uint32(4294967295)}, true, false},
+	{`{a} == 9007199254740992`, map[string]interface{}{"a": uint64(1 << 53)}, true, false},
+	{`{a} == 9223372036854775808`, map[string]interface{}{"a": uint64(1 << 63)}, true, false},
+	{`{a} == -9007199254740992`, map[string]interface{}{"a": int64(-1 << 53)}, true, false},
+	{`{a} > 10`, map[string]interface{}{"a": score(11)}, true, false},
+	{`{a} == 3`, map[string]interface{}{"a": level(3)}, true, false},
+	{`{a} == 0.5`, map[string]interface{}{"a": ratio(0.5)}, true, false},
+	{`{a} == "bob"`, map[string]interface{}{"a": label("bob")}, true, false},
+	{`{a}`, map[string]interface{}{"a": flag(true)}, true, false},
+	{`{a} CONTAINS "b"`, map[string]interface{}{"a": labels{"a", "b"}}, true, false},
+	{`{a} CONTAINS "b"`, map[string]interface{}{"a": []label{"a", "b"}}, true, false},
+	{`{a} CONTAINS 2`, map[string]interface{}{"a": scores{1, 2}}, true, false},
+	{`{a} CONTAINS 2`, map[string]interface{}{"a": []uint16{1, 2}}, true, false},
+	{`{a} CONTAINS 2`, map[string]interface{}{"a": []int8{1, 3}}, false, false},
+	{`{a} CONTAINS 1.5`, map[string]interface{}{"a": readings{1.5}}, true, false},
+
+	// exact integers
+
+	{`{a} == 9007199254740993`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	{`{a} == 9007199254740992`, map[string]interface{}{"a": int64(exactID)}, false, false},
+	{`{a} != 9007199254740992`, map[string]interface{}{"a": exactID}, true, false},
+	{`{a} > 9007199254740992`, map[string]interface{}{"a": uint64(exactID)}, true, false},
+	{`{a} >= 9007199254740994`, map[string]interface{}{"a": int64(exactID)}, false, false},
+	{`{a} < 9007199254740994`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	{`{a} <= 9007199254740992`, map[string]interface{}{"a": int64(exactID)}, false, false},
+	{`{a} == {b}`, map[string]interface{}{"a": int64(exactID), "b": uint64(exactID)}, true, false},
+	{`{a} == {b}`, map[string]interface{}{"a": int64(exactID), "b": int64(exactID - 1)}, false, false},
+	{`{a} == 18446744073709551615`, map[string]interface{}{"a": uint64(math.MaxUint64)}, true, false},
+	{`{a} == 18446744073709551614`, map[string]interface{}{"a": uint64(math.MaxUint64)}, false, false},
+	{`{a} > 9223372036854775807`, map[string]interface{}{"a": uint64(1 << 63)}, true, false},
+	{`{a} < -9223372036854775807`, map[string]interface{}{"a": int64(math.MinInt64)}, true, false},
+	{`{a} < {b}`, map[string]interface{}{"a": int64(-1), "b": uint64(math.MaxUint64)}, true, false},
+	{`{a} BETWEEN 9007199254740992 AND 9007199254740992`, map[string]interface{}{"a": int64(exactID)}, false, false},
+	{`{a} BETWEEN 9007199254740993 AND 9007199254740994`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	{`{a} IN [1, 9007199254740993]`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	{`{a} IN [1, 9007199254740992]`, map[string]interface{}{"a": int64(exactID)}, false, false},
+	{`{a} NOT IN [9007199254740992]`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	{`{ids} CONTAINS 9007199254740993`, map[string]interface{}{"ids": []int64{1, exactID}}, true, false},
+	{`{ids} CONTAINS 9007199254740992`, map[string]interface{}{"ids": []int64{1, exactID}}, false, false},
+	{`{ids} CONTAINS 9007199254740992`, map[string]interface{}{"ids": []uint64{1, exactID}}, false, false},
+	{`{ids} CONTAINS 9007199254740993`, map[string]interface{}{"ids": []interface{}{json.Number("9007199254740993")}}, true, false},
+	{`{ids} CONTAINS 9007199254740992`, map[string]interface{}{"ids": NewMapNumberCollection([]interface{}{int64(exactID)})}, false, false},
+	{`{ids} CONTAINS 9007199254740993`, map[string]interface{}{"ids": NewMapNumberCollection([]interface{}{int64(exactID)})}, true, false},
+	{`{a} == 9007199254740993`, map[string]interface{}{"a": json.Number("9007199254740993")}, true, false},
+	{`{a} + 1 == 9007199254740994`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	{`{a} - 1 == 9007199254740993`, map[string]interface{}{"a": int64(exactID)}, false, false},
+	{`{a} * 3 == 27021597764222979`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	{`{a} % 10 == 3`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	{`-{a} == -9007199254740993`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	// Floats compare within the epsilon.
+	{`{a} == 9007199254740992.0`, map[string]interface{}{"a": int64(exactID)}, true, false},
+	{`{a} == 9007199254740992`, map[string]interface{}{"a": float64(exactID)}, true, false},
+	{`{ids} CONTAINS 9007199254740992`, map[string]interface{}{"ids": []float64{exactID}}, true, false},
+	{`{ids} CONTAINS 2`, map[string]interface{}{"ids": NewMapNumberCollection([]interface{}{1, 2.0})}, true, false},
 }
 
 func TestValid(t *testing.T) {
@@ -247,6 +541,334 @@ func TestValid(t *testing.T) {
 	}
 }
 
+// sexpr renders the tree in prefix notation, ignoring explicit parentheses,
+// so the shapes of two trees can be compared.
+func sexpr(e Expr) string {
+	switch n := e.(type) {
+	case *ParenExpr:
+		return sexpr(n.Expr)
+	case *BinaryExpr:
+		return fmt.Sprintf("(%s %s %s)", n.Op, sexpr(n.LHS), sexpr(n.RHS))
+	case *UnaryExpr:
+		return fmt.Sprintf("(%s %s)", n.Op, sexpr(n.Expr))
+	case *BetweenExpr:
+		return fmt.Sprintf("(%s %s %s %s)", n.Op, sexpr(n.Expr), sexpr(n.Lower), sexpr(n.Upper))
+	default:
+		return e.String()
+	}
+}
+
+func mustParse(t *testing.T, cond string) Expr {
+	t.Helper()
+	expr, err := NewParser(strings.NewReader(cond)).Parse()
+	if err != nil {
+		t.Fatalf("Unexpected error parsing %q: %s", cond, err)
+	}
+	return expr
+}
+
+func TestPrecedence(t *testing.T) {
+	var tests = []struct {
+		cond string
+		tree string
+	}{
+		{`{a} OR {b} AND {c} == 1`, `(OR a (AND b (== c 1.000)))`},
+		{`{a} == 1 AND {b} OR {c}`, `(OR (AND (== a 1.000) b) c)`},
+		{`{a} AND {b} == 1 OR {c} == 2 AND {d}`, `(OR (AND a (== b 1.000)) (AND (== c 2.000) d))`},
+		{`{a} OR {b} XOR {c}`, `(XOR (OR a b) c)`},
+		{`{a} AND {b} NAND {c} AND {d}`, `(AND (NAND (AND a b) c) d)`},
+		{`{a} == {b} != {c}`, `(!= (== a b) c)`},
+		{`({a} OR {b}) AND {c}`, `(AND (OR a b) c)`},
+		{`NOT {a} == 1 AND !{b} OR NOT ({c} OR {d})`, `(OR (AND (NOT (== a 1.000)) (NOT b)) (NOT (OR c d)))`},
+		{`{a} != 1 AND {b} !~ "x"`, `(AND (!= a 1.000) (!~ b /x/))`},
+		{`{a} + {b} * {c} > {d} - 1`, `(> (+ a (* b c)) (- d 1.000))`},
+		{`{a} - {b} - {c} / {d} % 2`, `(- (- a b) (% (/ c d) 2.000))`},
+		{`-{a} * -1 == -{b}`, `(== (* (- a) -1.000) (- b))`},
+		{`{a} / 2 =~ /x/`, `(=~ (/ a 2.000) /x/)`},
+		{`{a} IN [1] AND {b} NOT CONTAINS "x" OR {c} =~ "y"`, `(OR (AND (IN a [1]) (NOT CONTAINS b "x")) (=~ c /y/))`},
+		{`{a} BETWEEN 1 AND 2 AND {b}`, `(AND (BETWEEN a 1.000 2.000) b)`},
+		{`{a} NOT BETWEEN {b} - 1 AND {b} + 1 OR {c}`, `(OR (NOT BETWEEN a (- b 1.000) (+ b 1.000)) c)`},
+		{`NOT {a} BETWEEN 1 AND 2`, `(NOT (BETWEEN a 1.000 2.000))`},
+		{`{a} STARTS WITH "x" AND {b} NOT ENDS WITH "y" OR {c} NOT LIKE "z%"`, `(OR (AND (STARTS WITH a "x") (NOT ENDS WITH b "y")) (NOT LIKE c "z%"))`},
+		{`NOT {a} MATCHES "*.x" AND starts({b})`, `(AND (NOT (MATCHES a "*.x")) starts(b))`},
+		{`{a} =* "x" OR {b} NOT IIN ["y"] AND {c} ILIKE "z"`, `(OR (=* a "x") (AND (NOT IIN b [y]) (ILIKE c "z")))`},
+		{`{a} !* {b} =~ /x/i`, `(=~ (!* a b) /(?i)x/)`},
+		{`{a} IS NULL AND NOT EXISTS {b} OR {c} + 1 IS NOT NULL`, `(OR (AND (IS a NULL) (NOT (EXISTS b))) (IS NOT (+ c 1.000) NULL))`},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.tree, sexpr(mustParse(t, test.cond)), test.cond)
+	}
+}
+
+// randomCondition returns a random condition without redundant parentheses
+// along with the same condition fully parenthesised according to
+// Token.Precedence, where operators of equal precedence associate to the left.
+func randomCondition(rnd *rand.Rand, depth int) (string, string) {
+	operands := []string{`{a}`, `{b}`, `true`, `1`, `"x"`, `[1,2]`}
+	operators := []Token{}
+	for tok := ILLEGAL; tok < Token(len(tokens)); tok++ {
+		if !tok.isOperator() {
+			continue
+		}
+		// BETWEEN takes two operands and IS only NULL, they are covered
+		// by validTestData.
+		if tok == BETWEEN || tok == NOTBETWEEN || tok == IS || tok == ISNOT {
+			continue
+		}
+		operators = append(operators, tok)
+	}
+
+	operand := func() (string, string) {
+		if depth > 0 && rnd.Intn(4) == 0 {
+			flat, ref := randomCondition(rnd, depth-1)
+			return "(" + flat + ")", "(" + ref + ")"
+		}
+		s := operands[rnd.Intn(len(operands))]
+		return s, s
+	}
+
+	flat, ref := operand()
+	out := []string{ref}
+	stack := []Token{}
+	reduce := func() {
+		op := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		l, r := out[len(out)-2], out[len(out)-1]
+		out = append(out[:len(out)-2], fmt.Sprintf("(%s %s %s)", l, op, r))
+	}
+
+	for i := rnd.Intn(6); i > 0; i-- {
+		op := operators[rnd.Intn(len(operators))]
+		for len(stack) > 0 && stack[len(stack)-1].Precedence() >= op.Precedence() {
+			reduce()
+		}
+		f, r := operand()
+		flat += " " + op.String() + " " + f
+		stack = append(stack, op)
+		out = append(out, r)
+	}
+	for len(stack) > 0 {
+		reduce()
+	}
+
+	return flat, out[0]
+}
+
+func TestPrecedenceMatchesParenthesisedReference(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 2000; i++ {
+		flat, ref := randomCondition(rnd, 2)
+		if !assert.Equal(t, sexpr(mustParse(t, ref)), sexpr(mustParse(t, flat)), flat) {
+			break
+		}
+	}
+}
+
+// operandExpected lists operandTokens as reported by ParseError.
+var operandExpected = func() string {
+	names := []string{}
+	for _, tok := range operandTokens {
+		names = append(names, tok.String())
+	}
+	return strings.Join(names, ", ")
+}()
+
+func TestParseError(t *testing.T) {
+	var tests = []struct {
+		cond     string
+		line     int
+		column   int
+		offset   int
+		token    Token
+		expected []Token
+		snippet  string
+		msg      string
+	}{
+		{
+			"{a} == 1 )", 1, 10, 9, RPAREN, []Token{EOF},
+			"{a} == 1 )\n         ^",
+			"found ), expected EOF at line 1, column 10",
+		},
+		{
+			"({a} == 1", 1, 10, 9, EOF, []Token{RPAREN},
+			"({a} == 1\n         ^",
+			"found EOF, expected ) at line 1, column 10",
+		},
+		{
+			"{a} == 1 AND\n\t{b} == DEMO", 2, 9, 21, NAME, operandTokens,
+			"\t{b} == DEMO\n\t       ^",
+			"found DEMO, expected " + operandExpected + " at line 2, column 9",
+		},
+		{
+			"{a} <> 1", 1, 6, 5, GT, operandTokens,
+			"{a} <> 1\n     ^",
+			"found >, expected " + operandExpected + " at line 1, column 6",
+		},
+		{
+			"{a} BETWEEN 1 OR 2", 1, 15, 14, OR, []Token{AND},
+			"{a} BETWEEN 1 OR 2\n              ^",
+			"found OR, expected AND at line 1, column 15",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := NewParser(strings.NewReader(test.cond)).Parse()
+		perr, ok := err.(*ParseError)
+		if !assert.True(t, ok, test.cond) {
+			continue
+		}
+		assert.Equal(t, test.line, perr.Pos.Line, test.cond)
+		assert.Equal(t, test.column, perr.Pos.Column, test.cond)
+		assert.Equal(t, test.offset, perr.Pos.Offset, test.cond)
+		assert.Equal(t, test.token, perr.Token, test.cond)
+		assert.Equal(t, test.expected, perr.Expected, test.cond)
+		assert.Equal(t, test.snippet, perr.Snippet, test.cond)
+		assert.Equal(t, test.msg, perr.Error(), test.cond)
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	var tests = []struct {
+		cond   string
+		tree   string
+		errors []string
+	}{
+		{`{a} == 1 AND {b} == 2`, `(AND (== a 1.000) (== b 2.000))`, nil},
+		{
+			`{a} == DEMO AND {b} == 'x' OR {c} == 3`,
+			`(OR (AND (== a <bad expression>) (== b <bad expression>)) (== c 3.000))`,
+			[]string{
+				"found DEMO, expected " + operandExpected + " at line 1, column 8",
+				"illegal token 'x' at line 1, column 24",
+			},
+		},
+		{
+			`({a} == ) AND ({b} IN [1, "x"] OR {c}`,
+			`(AND (== a <bad expression>) (OR (IN b <bad expression>) c))`,
+			[]string{
+				"found ), expected " + operandExpected + " at line 1, column 9",
+				"the items in the array are not all number at line 1, column 23",
+				"found EOF, expected ) at line 1, column 38",
+			},
+		},
+		{
+			`{a} == 1 ) AND {b} 5 OR {d} <> 2`,
+			`(OR (AND (== a 1.000) b) (< d <bad expression>))`,
+			[]string{
+				"found ), expected EOF at line 1, column 10",
+				"found 5, expected operator at line 1, column 20",
+				"found >, expected " + operandExpected + " at line 1, column 30",
+			},
+		},
+		{`{a} ==`, `(== a <bad expression>)`, []string{"found EOF, expected " + operandExpected + " at line 1, column 7"}},
+	}
+
+	for _, test := range tests {
+		expr, errs := NewParser(strings.NewReader(test.cond)).ParseAll()
+		assert.Equal(t, test.tree, sexpr(expr), test.cond)
+
+		msgs := []string(nil)
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		assert.Equal(t, test.errors, msgs, test.cond)
+
+		_, err := NewParser(strings.NewReader(test.cond)).Parse()
+		if len(test.errors) == 0 {
+			assert.NoError(t, err, test.cond)
+		} else if assert.Error(t, err, test.cond) {
+			assert.Equal(t, test.errors[0], err.Error(), test.cond)
+		}
+	}
+
+	for _, cond := range append(invalidTestData, "((((", "))))", "AND OR", "{a} == ( ) ) (") {
+		_, errs := NewParser(strings.NewReader(cond)).ParseAll()
+		assert.NotEmpty(t, errs, cond)
+	}
+}
+
+func TestTimeAndDurationLiterals(t *testing.T) {
+	var tests = []struct {
+		cond string
+		str  string
+	}{
+		{"{at} > 2024-01-01", "at > 2024-01-01T00:00:00Z"},
+		{"{at} > 2024-01-01T10:30:00+02:00", "at > 2024-01-01T08:30:00Z"},
+		{"{at} > 2024-01-01T10:30:00.25Z", "at > 2024-01-01T10:30:00.25Z"},
+		{"{d} > 90m", "d > 90m"},
+		{"{d} > 1h30m", "d > 90m"},
+		{"{d} > 14d", "d > 2w"},
+		{"{d} > 1500us", "d > 1500us"},
+		{"{d} > 10ns", "d > 10ns"},
+	}
+
+	for _, test := range tests {
+		expr := mustParse(t, test.cond)
+		assert.Equal(t, test.str, expr.String(), test.cond)
+
+		// The literal parses back to itself.
+		rhs := expr.(*BinaryExpr).RHS.String()
+		assert.Equal(t, rhs, mustParse(t, "{x} > "+rhs).(*BinaryExpr).RHS.String(), test.cond)
+	}
+
+	// A year followed by anything else than a month and a day is a
+	// number.
+	for cond, str := range map[string]string{
+		"{x} == 2024-1":     "(== x (- 2024.000 1.000))",
+		"{x} == 2024 - 1":   "(== x (- 2024.000 1.000))",
+		"{x} == 1999-{y}":   "(== x (- 1999.000 y))",
+		"{x} == 2024-01":    "(== x (- 2024.000 1.000))",
+		"{x} > 2024-01-01":  "(> x 2024-01-01T00:00:00Z)",
+		"{x} == 2024-1-1":   "(== x (- (- 2024.000 1.000) 1.000))",
+		"{x} == 2024-{y}-1": "(== x (- (- 2024.000 y) 1.000))",
+	} {
+		assert.Equal(t, str, sexpr(mustParse(t, cond)), cond)
+	}
+	r, err := Evaluate(mustParse(t, "{x} == 2024-1"), map[string]interface{}{"x": 2023})
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	// A number followed by a word which is not a duration unit is a
+	// number.
+	for cond, str := range map[string]string{
+		"{a} > 5AND {b}":   "(AND (> a 5.000) b)",
+		"{a} > 5or {b}":    "(OR (> a 5.000) b)",
+		"{a} > 5m AND {b}": "(AND (> a 5m) b)",
+		"{a} > 5h30m":      "(> a 330m)",
+	} {
+		assert.Equal(t, str, sexpr(mustParse(t, cond)), cond)
+	}
+	r, err = Evaluate(mustParse(t, "{a} > 5AND {b}"), map[string]interface{}{"a": 6, "b": true})
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	for _, d := range []time.Duration{time.Nanosecond, 1500 * time.Microsecond, 90 * time.Second, 36 * time.Hour, 14 * 24 * time.Hour} {
+		parsed, err := ParseDuration(FormatDuration(d))
+		assert.NoError(t, err)
+		assert.Equal(t, d, parsed)
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	expr := mustParse(t, `{a} == "say \"hi\"\n" AND {b} LIKE "100\\%"`)
+	lit := expr.(*BinaryExpr).LHS.(*BinaryExpr).RHS.(*StringLiteral)
+	assert.Equal(t, "say \"hi\"\n", lit.Val)
+	assert.Equal(t, `a == "say \"hi\"\n" AND b LIKE "100\\%"`, expr.String())
+
+	// The quoted literal parses back to itself.
+	assert.Equal(t, lit, mustParse(t, "{a} == "+lit.String()).(*BinaryExpr).RHS)
+
+	r, err := Evaluate(expr, map[string]interface{}{"a": "say \"hi\"\n", "b": "100%"})
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	// Only the escapes of Go strings are valid.
+	_, err = NewParser(strings.NewReader(`{b} LIKE "100\%"`)).Parse()
+	assert.Error(t, err)
+}
+
 func TestExpressionsVariableNames(t *testing.T) {
 	cond := "{@foo}{a} == true and {bar} == true or {var9} > 10"
 	p := NewParser(strings.NewReader(cond))
@@ -312,6 +934,15 @@ func TestSetDefaultEpsilon(t *testing.T) {
 		assert.True(t, r)
 		assert.NoError(t, err)
 	})
+
+	t.Run("slices of numbers do not read the epsilon", func(t *testing.T) {
+		SetDefaultEpsilon(1e-3)
+		assert.False(t, intSlice{1, 2}.Has(1.0001))
+		assert.True(t, int64Slice{1, 2}.Has(2))
+		assert.False(t, int32Slice{1, 2}.Has(2.0001))
+		assert.True(t, float32Slice{1.1}.Has(1.1))
+		assert.False(t, float32Slice{1.1}.Has(1.1001))
+	})
 }
 
 func TestReadmeExample(t *testing.T) {
@@ -402,3 +1033,11 @@ func BenchmarkLongSliceString(b *testing.B) {
 		Evaluate(expr, args)
 	}
 }
+
+func TestTokenValuesAreStable(t *testing.T) {
+	// Tokens are only ever appended, the values of the original ones do
+	// not change.
+	assert.Equal(t, []Token{3, 4, 5, 6, 7, 8}, []Token{IDENT, NUMBER, STRING, ARRAY, TRUE, FALSE})
+	assert.Equal(t, []Token{11, 13, 17, 23, 24, 26}, []Token{AND, EQ, GT, IN, NOTIN, NOTCONTAINS})
+	assert.Equal(t, []Token{28, 29}, []Token{LPAREN, RPAREN})
+}
@@ -0,0 +1,528 @@
+package conditions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Program is an expression compiled for repeated evaluation. Unlike
+// Evaluate, evaluating a program does not walk the tree nor allocate
+// literals for booleans, numbers, strings, slices of strings and
+// collections. It is safe for concurrent use.
+type Program struct {
+	expr   Expr
+	config *evalConfig
+	root   evalFunc
+	pool   sync.Pool
+}
+
+// evalFunc evaluates a compiled node.
+type evalFunc func(ev *evaluation) (value, error)
+
+// valueKind is the type of a value.
+type valueKind uint8
+
+const (
+	// value of any other literal, held by expr
+	kindExpr valueKind = iota
+	kindBool
+	kindNumber
+	kindString
+	// slice of strings held by strs
+	kindStrings
+	// StringCollection or NumberCollection held by coll
+	kindCollection
+)
+
+// value is the result of a compiled node. Booleans, numbers, strings,
+// slices of strings and collections are held unboxed, other values as
+// literals.
+type value struct {
+	kind valueKind
+	b    bool
+	n    NumberLiteral
+	s    string
+	strs []string
+	coll interface{}
+	// literal of the value, if already allocated
+	expr Expr
+}
+
+// valueOfExpr returns the value of a literal.
+func valueOfExpr(e Expr) value {
+	switch n := e.(type) {
+	case *BooleanLiteral:
+		return value{kind: kindBool, b: n.Val, expr: e}
+	case *NumberLiteral:
+		return value{kind: kindNumber, n: *n, expr: e}
+	case *StringLiteral:
+		return value{kind: kindString, s: n.Val, expr: e}
+	}
+	return value{kind: kindExpr, expr: e}
+}
+
+// literal returns the value as a literal.
+func (v value) literal() Expr {
+	if v.expr != nil {
+		return v.expr
+	}
+	switch v.kind {
+	case kindBool:
+		return &BooleanLiteral{Val: v.b}
+	case kindNumber:
+		n := v.n
+		return &n
+	case kindString:
+		return &StringLiteral{Val: v.s}
+	case kindStrings:
+		return &SliceStringLiteral{Val: v.strs}
+	case kindCollection:
+		switch c := v.coll.(type) {
+		case StringCollection:
+			return &StringCollectionLiteral{Val: c}
+		case NumberCollection:
+			return &NumberCollectionLiteral{Val: c}
+		}
+	}
+	return nil
+}
+
+// Compile compiles expr for repeated evaluation with the given options.
+// Expressions the parser failed on cannot be compiled.
+func Compile(expr Expr, opts ...EvalOption) (*Program, error) {
+	return NewEvaluator(opts...).Compile(expr)
+}
+
+// Compile compiles expr for repeated evaluation with the options of the
+// evaluator. Expressions exceeding its limits are rejected here rather
+// than at each evaluation.
+func (e *Evaluator) Compile(expr Expr) (*Program, error) {
+	if expr == nil {
+		return nil, fmt.Errorf("provided expression is nil")
+	}
+
+	var bad *BadExpr
+	WalkFunc(expr, func(n Node) {
+		if b, ok := n.(*BadExpr); ok && bad == nil {
+			bad = b
+		}
+	})
+	if bad != nil {
+		return nil, fmt.Errorf("cannot compile bad expression: %s", bad.Err)
+	}
+
+	if err := e.config.limits.check(expr); err != nil {
+		return nil, err
+	}
+
+	p := &Program{expr: expr, config: e.config}
+	p.root = e.config.compileNode(expr)
+	p.pool.New = func() interface{} { return &evaluation{} }
+	return p, nil
+}
+
+// Eval evaluates the program using given arg resolver. It returns the same
+// result as EvaluateWithArgResolver on the compiled expression.
+func (p *Program) Eval(args ArgResolver) (bool, error) {
+	return p.eval(nil, args)
+}
+
+// EvalContext evaluates the program like EvaluateContext, stopping with a
+// *CanceledError once ctx is done.
+func (p *Program) EvalContext(ctx context.Context, args ArgResolver) (bool, error) {
+	return p.eval(ctx, args)
+}
+
+func (p *Program) eval(ctx context.Context, args ArgResolver) (bool, error) {
+	ev := p.pool.Get().(*evaluation)
+	ev.reset(ctx, p.config, args)
+	defer p.pool.Put(ev)
+
+	result, err := p.root(ev)
+	if ev.missingAsFalse(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if result.kind != kindBool {
+		return false, fmt.Errorf("unexpected result of the root expression: %#v", result.literal())
+	}
+	return result.b, nil
+}
+
+// String returns the compiled expression.
+func (p *Program) String() string {
+	return p.expr.String()
+}
+
+// compileNode compiles expr, falling back to the interpreter for the nodes
+// without compiled form.
+func (c *evalConfig) compileNode(expr Expr) evalFunc {
+	switch n := expr.(type) {
+	case *ParenExpr:
+		return c.compileNode(n.Expr)
+	case *BinaryExpr:
+		if n.Op != IS && n.Op != ISNOT {
+			return c.compileBinary(n)
+		}
+	case *UnaryExpr:
+		if n.Op != EXISTS {
+			return c.compileUnary(n)
+		}
+	case *BetweenExpr:
+		return c.compileBetween(n)
+	case *VarRef:
+		return compileVarRef(n)
+	case *BooleanLiteral, *NumberLiteral, *StringLiteral:
+		v := valueOfExpr(n)
+		return func(*evaluation) (value, error) { return v, nil }
+	}
+
+	return func(ev *evaluation) (value, error) {
+		r, err := ev.evaluateSubtree(expr)
+		if err != nil {
+			return value{}, err
+		}
+		return valueOfExpr(r), nil
+	}
+}
+
+// compileVarRef compiles the resolution of a variable.
+func compileVarRef(n *VarRef) evalFunc {
+	return func(ev *evaluation) (value, error) {
+		v := ev.variable(n.Val)
+		if v.err != nil || v.lit != nil {
+			r, err := ev.literal(n.Val)
+			if err != nil {
+				return value{}, err
+			}
+			return valueOfExpr(r), nil
+		}
+
+		switch a := v.arg.(type) {
+		case bool:
+			return value{kind: kindBool, b: a}, nil
+		case float64:
+			return value{kind: kindNumber, n: NumberLiteral{Val: a}}, nil
+		case int:
+			return value{kind: kindNumber, n: intNumber(int64(a))}, nil
+		case int64:
+			return value{kind: kindNumber, n: intNumber(a)}, nil
+		case int32:
+			return value{kind: kindNumber, n: intNumber(int64(a))}, nil
+		case uint64:
+			return value{kind: kindNumber, n: uintNumber(a)}, nil
+		case float32:
+			return value{kind: kindNumber, n: NumberLiteral{Val: float64(a)}}, nil
+		case string:
+			return value{kind: kindString, s: a}, nil
+		case []string:
+			return value{kind: kindStrings, strs: a}, nil
+		case StringCollection, NumberCollection:
+			return value{kind: kindCollection, coll: a}, nil
+		}
+
+		r, err := ev.literal(n.Val)
+		if err != nil {
+			return value{}, err
+		}
+		return valueOfExpr(r), nil
+	}
+}
+
+// compileUnary compiles NOT and unary -.
+func (c *evalConfig) compileUnary(n *UnaryExpr) evalFunc {
+	operand := c.compileNode(n.Expr)
+	return func(ev *evaluation) (value, error) {
+		v, err := operand(ev)
+		if n.Op == NOT && ev.missingAsFalse(err) {
+			v, err = value{kind: kindBool}, nil
+		}
+		if err != nil {
+			return value{}, err
+		}
+
+		switch {
+		case n.Op == NOT && v.kind == kindBool:
+			return value{kind: kindBool, b: !v.b}, nil
+		case n.Op == SUB && v.kind == kindNumber:
+			return value{kind: kindNumber, n: negateNumber(&v.n)}, nil
+		}
+
+		r, err := applyUnaryOperator(n.Op, v.literal())
+		if err != nil {
+			return value{}, withNode(err, n)
+		}
+		return valueOfExpr(r), nil
+	}
+}
+
+// compileBinary compiles a binary operation, short-circuiting AND, OR and
+// NAND like the interpreter.
+func (c *evalConfig) compileBinary(n *BinaryExpr) evalFunc {
+	lhs, rhs := c.compileNode(n.LHS), c.compileNode(n.RHS)
+	apply := c.compileOperator(n.Op, n.RHS)
+
+	return func(ev *evaluation) (value, error) {
+		if err := ev.canceled(); err != nil {
+			return value{}, err
+		}
+
+		l, err := lhs(ev)
+		if operandFalse, resultFalse := ev.missingOperand(n.Op, err); resultFalse {
+			return value{kind: kindBool}, nil
+		} else if operandFalse {
+			l, err = value{kind: kindBool}, nil
+		}
+		if err != nil {
+			return value{}, err
+		}
+		if l.kind == kindBool {
+			if result, ok := shortCircuit(n.Op, l.b); ok {
+				return value{kind: kindBool, b: result}, nil
+			}
+		}
+
+		r, err := rhs(ev)
+		if operandFalse, resultFalse := ev.missingOperand(n.Op, err); resultFalse {
+			return value{kind: kindBool}, nil
+		} else if operandFalse {
+			r, err = value{kind: kindBool}, nil
+		}
+		if err != nil {
+			return value{}, err
+		}
+
+		result, err := apply(l, r)
+		if err != nil {
+			return value{}, withNode(err, n)
+		}
+		return result, nil
+	}
+}
+
+// compileOperator returns the application of op to values, which handles
+// booleans, numbers and strings directly and leaves any other operand to
+// applyOperator.
+func (c *evalConfig) compileOperator(op Token, rhs Expr) func(l, r value) (value, error) {
+	slow := func(l, r value) (value, error) {
+		result, err := c.applyOperator(op, l.literal(), r.literal())
+		if err != nil {
+			return value{}, err
+		}
+		return valueOfExpr(result), nil
+	}
+	boolean := func(b bool) (value, error) {
+		return value{kind: kindBool, b: b}, nil
+	}
+
+	switch op {
+	case AND, OR, XOR, NAND:
+		return func(l, r value) (value, error) {
+			if l.kind != kindBool || r.kind != kindBool {
+				return slow(l, r)
+			}
+			switch op {
+			case AND:
+				return boolean(l.b && r.b)
+			case OR:
+				return boolean(l.b || r.b)
+			case XOR:
+				return boolean(l.b != r.b)
+			}
+			return boolean(!(l.b && r.b))
+		}
+	case EQ, NEQ:
+		return func(l, r value) (value, error) {
+			if l.kind != r.kind {
+				return slow(l, r)
+			}
+			var eq bool
+			switch l.kind {
+			case kindBool:
+				eq = l.b == r.b
+			case kindNumber:
+				eq = numberEqual(&l.n, &r.n, c.epsilon)
+			case kindString:
+				eq = l.s == r.s
+			default:
+				return slow(l, r)
+			}
+			return boolean(eq == (op == EQ))
+		}
+	case LT, LTE, GT, GTE:
+		return func(l, r value) (value, error) {
+			var cmp int
+			switch {
+			case l.kind == kindNumber && r.kind == kindNumber:
+				if numberEqual(&l.n, &r.n, c.epsilon) && (op == LTE || op == GTE) {
+					return boolean(true)
+				}
+				if numberLess(&l.n, &r.n) {
+					cmp = -1
+				} else if numberLess(&r.n, &l.n) {
+					cmp = 1
+				}
+			case l.kind == kindString && r.kind == kindString:
+				cmp = strings.Compare(l.s, r.s)
+			default:
+				return slow(l, r)
+			}
+			switch op {
+			case LT:
+				return boolean(cmp < 0)
+			case LTE:
+				return boolean(cmp <= 0)
+			case GT:
+				return boolean(cmp > 0)
+			}
+			return boolean(cmp >= 0)
+		}
+	case ADD, SUB, MUL:
+		return func(l, r value) (value, error) {
+			if l.kind != kindNumber || r.kind != kindNumber {
+				return slow(l, r)
+			}
+			if n, ok := intArithmetic(op, &l.n, &r.n); ok {
+				return value{kind: kindNumber, n: n}, nil
+			}
+			v := value{kind: kindNumber}
+			switch op {
+			case ADD:
+				v.n.Val = l.n.Val + r.n.Val
+			case SUB:
+				v.n.Val = l.n.Val - r.n.Val
+			case MUL:
+				v.n.Val = l.n.Val * r.n.Val
+			}
+			return v, nil
+		}
+	case DIV:
+		return func(l, r value) (value, error) {
+			if l.kind != kindNumber || r.kind != kindNumber || r.n.Val == 0 {
+				return slow(l, r)
+			}
+			return value{kind: kindNumber, n: NumberLiteral{Val: l.n.Val / r.n.Val}}, nil
+		}
+	case IN, NOTIN:
+		return func(l, r value) (value, error) {
+			if found, ok := contains(r, l, c.epsilon); ok {
+				return boolean(found == (op == IN))
+			}
+			return slow(l, r)
+		}
+	case CONTAINS, NOTCONTAINS:
+		return func(l, r value) (value, error) {
+			if found, ok := contains(l, r, c.epsilon); ok {
+				return boolean(found == (op == CONTAINS))
+			}
+			return slow(l, r)
+		}
+	case EREG, NEREG, LIKE, NOTLIKE, ILIKE, NOTILIKE, MATCHES, NOTMATCHES:
+		if re, ok := rhs.(*RegexLiteral); ok {
+			match := op == EREG || op == LIKE || op == ILIKE || op == MATCHES
+			return func(l, r value) (value, error) {
+				if l.kind != kindString {
+					return slow(l, r)
+				}
+				return boolean(re.Val.MatchString(l.s) == match)
+			}
+		}
+	case STARTSWITH, NOTSTARTSWITH, ENDSWITH, NOTENDSWITH:
+		return func(l, r value) (value, error) {
+			if l.kind != kindString || r.kind != kindString {
+				return slow(l, r)
+			}
+			var found bool
+			if op == STARTSWITH || op == NOTSTARTSWITH {
+				found = strings.HasPrefix(l.s, r.s)
+			} else {
+				found = strings.HasSuffix(l.s, r.s)
+			}
+			return boolean(found == (op == STARTSWITH || op == ENDSWITH))
+		}
+	}
+
+	return slow
+}
+
+// compileBetween compiles a range check.
+func (c *evalConfig) compileBetween(n *BetweenExpr) evalFunc {
+	operands := []evalFunc{c.compileNode(n.Expr), c.compileNode(n.Lower), c.compileNode(n.Upper)}
+
+	return func(ev *evaluation) (value, error) {
+		var v [3]value
+		for i, operand := range operands {
+			r, err := operand(ev)
+			if ev.missingAsFalse(err) {
+				return value{kind: kindBool}, nil
+			} else if err != nil {
+				return value{}, err
+			}
+			v[i] = r
+		}
+
+		if v[0].kind == kindNumber && v[1].kind == kindNumber && v[2].kind == kindNumber {
+			x, lo, hi := &v[0].n, &v[1].n, &v[2].n
+			in := (numberLess(lo, x) || numberEqual(x, lo, c.epsilon)) && (numberLess(x, hi) || numberEqual(x, hi, c.epsilon))
+			return value{kind: kindBool, b: in == (n.Op == BETWEEN)}, nil
+		}
+
+		result, err := c.applyBetween(n.Op, v[0].literal(), v[1].literal(), v[2].literal())
+		if err != nil {
+			return value{}, withNode(err, n)
+		}
+		return valueOfExpr(result), nil
+	}
+}
+
+// contains looks v up in the collection c without allocating. It returns
+// false for ok if c is not a collection of values of the kind of v.
+func contains(c, v value, epsilon float64) (found, ok bool) {
+	switch c.kind {
+	case kindStrings:
+		if v.kind != kindString {
+			return false, false
+		}
+		for _, item := range c.strs {
+			if item == v.s {
+				return true, true
+			}
+		}
+		return false, true
+	case kindCollection:
+		switch a := c.coll.(type) {
+		case StringCollection:
+			if v.kind == kindString {
+				return a.Has(v.s), true
+			}
+		case NumberCollection:
+			if v.kind == kindNumber {
+				return hasNumber(a, &v.n, epsilon), true
+			}
+		}
+		return false, false
+	}
+
+	switch a := c.expr.(type) {
+	case *SliceStringLiteral:
+		if v.kind == kindString {
+			return a.has(v.s), true
+		}
+	case *StringCollectionLiteral:
+		if v.kind == kindString {
+			return a.Val.Has(v.s), true
+		}
+	case *SliceNumberLiteral:
+		if v.kind == kindNumber {
+			return a.has(&v.n, epsilon), true
+		}
+	case *NumberCollectionLiteral:
+		if v.kind == kindNumber {
+			return hasNumber(a.Val, &v.n, epsilon), true
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,28 @@
+//go:build !race
+// +build !race
+
+package conditions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The race detector makes sync.Pool drop items at random, so that the
+// evaluations allocate.
+
+func TestProgramDoesNotAllocate(t *testing.T) {
+	prog, err := Compile(mustParse(t, `{a} > 1 AND {b} == "x" AND {c} IN ["a", "b"] AND {d} =~ /^x/ AND {g} CONTAINS "b" AND {b} IN {h} AND NOT {e} OR {f} BETWEEN 1 AND 2`))
+	assert.NoError(t, err)
+	args := NewMapArgResolver(map[string]interface{}{"a": 2, "b": "x", "c": "b", "d": "xy", "e": false, "f": 1.5,
+		"g": []string{"a", "b"}, "h": NewMapStringCollection([]interface{}{"x"})})
+
+	r, err := prog.Eval(args)
+	assert.NoError(t, err)
+	assert.True(t, r)
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = prog.Eval(args)
+	})
+	assert.Equal(t, 0.0, allocs)
+}
@@ -0,0 +1,223 @@
+package conditions
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertSameEvaluation checks that the compiled expression evaluates like
+// the interpreter.
+func assertSameEvaluation(t *testing.T, cond string, args map[string]interface{}, opts ...EvalOption) {
+	t.Helper()
+	expr := mustParse(t, cond)
+	want, wantErr := Evaluate(expr, args, opts...)
+
+	prog, err := Compile(expr, opts...)
+	if !assert.NoError(t, err, cond) {
+		return
+	}
+	got, gotErr := prog.Eval(NewMapArgResolver(args))
+	assert.Equal(t, want, got, cond)
+	if wantErr != nil {
+		assert.EqualError(t, gotErr, wantErr.Error(), cond)
+	} else {
+		assert.NoError(t, gotErr, cond)
+	}
+}
+
+func TestProgramMatchesInterpreter(t *testing.T) {
+	for _, td := range validTestData {
+		assertSameEvaluation(t, td.cond, td.args)
+	}
+
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	args := map[string]interface{}{
+		"n": 3, "f": 2.5, "s": "abc", "b": true, "nil": nil,
+		"t": now, "d": time.Hour, "tags": []string{"a", "b"}, "nums": []int{1, 2},
+	}
+	var conds = []string{
+		`{n} + {f} * 2 == 8`, `{n} / 0 > 1`, `{n} % 2 == 1`, `-{n} < -{f}`, `-{s} == 1`,
+		`{n} >= 3.0000001 AND {n} <= 3 AND {n} > 2 AND {n} < 4`, `{s} > "abb" AND {s} <= "abc"`,
+		`{s} > 1`, `{b} == true`, `{b} == 1`, `{b} != false`, `{s} =* "ABC"`,
+		`{s} IN ["abc", "x"]`, `{s} NOT IN ["x"]`, `{n} IN [1, 3]`, `{n} NOT IN [1.5]`, `{b} IN ["x"]`,
+		`{s} IN {tags}`, `{tags} CONTAINS "a"`, `{nums} CONTAINS 2`,
+		`{s} =~ /^a/`, `{s} !~ "c$"`, `{n} =~ /3/`, `{s} =~ {s}`,
+		`{s} STARTS WITH "ab"`, `{s} NOT ENDS WITH "bc"`, `{s} LIKE "a%"`, `{s} MATCHES "*c"`,
+		`{n} BETWEEN 1 AND 3`, `{n} NOT BETWEEN 4 AND 5`, `{s} BETWEEN "a" AND "b"`, `{n} BETWEEN "a" AND 5`,
+		`{t} > 2024-01-01 AND {t} - {d} < now()`, `{d} * 2 == 2h`,
+		`{nil} IS NULL AND {missing} IS NULL`, `EXISTS {nil}`, `NOT EXISTS {missing}`, `{nil} == NULL`,
+		`len({tags}) == 2 AND upper({s}) == "ABC"`,
+		`{b} AND {missing}`, `NOT {b} AND {missing}`, `{b} OR {missing}`, `{b} XOR {b}`, `{b} NAND {b}`,
+		`{n} AND {b}`, `NOT {n}`, `{missing} == 1`, `{n}`, `({n} > 1)`,
+	}
+	for _, cond := range conds {
+		assertSameEvaluation(t, cond, args, WithClock(func() time.Time { return now }))
+	}
+
+	var policies = []EvalOption{
+		WithMissingVariables(MissingVariableNull),
+		WithMissingVariables(MissingVariableFalse),
+		WithDefaults(map[string]interface{}{"missing": 1}),
+	}
+	for _, opt := range policies {
+		for _, cond := range []string{`{missing} == 1 OR {n} == 3`, `NOT {missing} == 1`, `{missing} + 1 > 1`, `{missing} BETWEEN 0 AND 2`, `{missing}`} {
+			assertSameEvaluation(t, cond, args, opt)
+		}
+	}
+}
+
+func TestProgram(t *testing.T) {
+	prog, err := Compile(mustParse(t, `{user} != "" AND {expensive} > 3`))
+	assert.NoError(t, err)
+	assert.Equal(t, `user != "" AND expensive > 3.000`, prog.String())
+
+	resolver := &countingResolver{args: map[string]interface{}{"user": ""}}
+	r, err := prog.Eval(resolver)
+	assert.NoError(t, err)
+	assert.False(t, r)
+	assert.Equal(t, []string{"user"}, resolver.resolved)
+
+	r, err = prog.Eval(NewMapArgResolver(map[string]interface{}{"user": "john", "expensive": 4}))
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	_, err = Compile(nil)
+	assert.Error(t, err)
+	expr, _ := NewParser(strings.NewReader(`{a} == 1 AND {b} ==`)).ParseAll()
+	_, err = Compile(expr)
+	assert.EqualError(t, err, "cannot compile bad expression: found EOF, expected "+operandExpected+" at line 1, column 20")
+}
+
+var benchmarkCondition = `{status} == "active" AND {age} >= 18 AND {country} IN ["DE", "AT", "CH"] AND ({score} * 2 > 150 OR {vip})`
+
+var benchmarkArgs = map[string]interface{}{"status": "active", "age": 30, "country": "AT", "score": 70, "vip": true}
+
+func BenchmarkEvaluate(b *testing.B) {
+	expr, err := NewParser(strings.NewReader(benchmarkCondition)).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	args := NewMapArgResolver(benchmarkArgs)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EvaluateWithArgResolver(expr, args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgramEval(b *testing.B) {
+	expr, err := NewParser(strings.NewReader(benchmarkCondition)).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	prog, err := Compile(expr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	args := NewMapArgResolver(benchmarkArgs)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prog.Eval(args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkTags() map[string]interface{} {
+	tags := make([]string, 10000)
+	for i := range tags {
+		tags[i] = fmt.Sprintf("tag-%d", i)
+	}
+	return map[string]interface{}{"tags": tags}
+}
+
+// benchmarkTagSet returns the tags of benchmarkTags as a collection.
+func benchmarkTagSet() map[string]interface{} {
+	tags := benchmarkTags()["tags"].([]string)
+	items := make([]interface{}, len(tags))
+	for i, tag := range tags {
+		items[i] = tag
+	}
+	return map[string]interface{}{"tags": NewMapStringCollection(items)}
+}
+
+func BenchmarkEvaluateContains(b *testing.B) {
+	expr, err := NewParser(strings.NewReader(`{tags} CONTAINS "tag-5000"`)).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	args := NewMapArgResolver(benchmarkTags())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EvaluateWithArgResolver(expr, args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgramEvalContains(b *testing.B) {
+	expr, err := NewParser(strings.NewReader(`{tags} CONTAINS "tag-5000"`)).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	prog, err := Compile(expr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	args := NewMapArgResolver(benchmarkTags())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prog.Eval(args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvaluateContainsCollection(b *testing.B) {
+	expr, err := NewParser(strings.NewReader(`{tags} CONTAINS "tag-5000"`)).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	args := NewMapArgResolver(benchmarkTagSet())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EvaluateWithArgResolver(expr, args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgramEvalContainsCollection(b *testing.B) {
+	expr, err := NewParser(strings.NewReader(`{tags} CONTAINS "tag-5000"`)).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	prog, err := Compile(expr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	args := NewMapArgResolver(benchmarkTagSet())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prog.Eval(args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
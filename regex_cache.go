@@ -0,0 +1,75 @@
+package conditions
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// regexCacheSize is the number of patterns kept compiled by regexCache.
+const regexCacheSize = 256
+
+// regexCache holds the regular expressions compiled during evaluations,
+// for patterns which are not known at parse time.
+var regexCache = newRegexpCache(regexCacheSize)
+
+// regexpCache is a least recently used cache of compiled regular
+// expressions. It is safe for concurrent use.
+type regexpCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // most recently used first
+	items map[string]*list.Element
+}
+
+type regexpCacheEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newRegexpCache(size int) *regexpCache {
+	return &regexpCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// compile returns the compiled pattern, compiling it unless it is cached.
+// Invalid patterns are not cached.
+func (c *regexpCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	if e, ok := c.items[pattern]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*regexpCacheEntry).re, nil
+	}
+	c.mu.Unlock()
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[pattern]; ok {
+		// Compiled concurrently meanwhile.
+		c.order.MoveToFront(e)
+		return re, nil
+	}
+	c.items[pattern] = c.order.PushFront(&regexpCacheEntry{pattern: pattern, re: re})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*regexpCacheEntry).pattern)
+	}
+	return re, nil
+}
+
+// len returns the number of cached patterns.
+func (c *regexpCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,106 @@
+package conditions
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegexpCache(t *testing.T) {
+	c := newRegexpCache(2)
+
+	a, err := c.compile("a+")
+	assert.NoError(t, err)
+	again, err := c.compile("a+")
+	assert.NoError(t, err)
+	assert.True(t, a == again, "cached pattern recompiled")
+
+	_, err = c.compile("(")
+	assert.Error(t, err)
+	assert.Equal(t, 1, c.len())
+
+	_, _ = c.compile("b+")
+	_, _ = c.compile("a+") // a+ is now more recent than b+
+	_, _ = c.compile("c+")
+	assert.Equal(t, 2, c.len())
+	again, _ = c.compile("a+")
+	assert.True(t, a == again, "recently used pattern evicted")
+	assert.NotContains(t, c.items, "b+")
+
+	for i := 0; i < 10; i++ {
+		_, _ = c.compile(fmt.Sprintf("x%d", i))
+	}
+	assert.Equal(t, 2, c.len())
+}
+
+func TestRegexLiteral(t *testing.T) {
+	expr := mustParse(t, `{a} =~ /^a\/b$/i AND {b} !~ "x/y" AND {c} =~ {pattern}`)
+	assert.Equal(t, `a =~ /(?i)^a\/b$/ AND b !~ /x\/y/ AND c =~ pattern`, expr.String())
+	assert.ElementsMatch(t, []string{"a", "b", "c", "pattern"}, Variables(expr))
+
+	var patterns []string
+	WalkFunc(expr, func(n Node) {
+		if re, ok := n.(*RegexLiteral); ok {
+			patterns = append(patterns, re.Val.String())
+
+			// The printed literal parses back to the same pattern.
+			back := mustParse(t, "{a} =~ "+re.String()).(*BinaryExpr).RHS.(*RegexLiteral)
+			assert.Equal(t, re.Val.String(), back.Val.String())
+		}
+	})
+	assert.Equal(t, []string{`(?i)^a/b$`, `x/y`}, patterns)
+
+	r, err := Evaluate(expr, map[string]interface{}{"a": "A/B", "b": "x", "c": "abc", "pattern": "^ab"})
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	var tests = []struct {
+		cond string
+		msg  string
+	}{
+		{`{a} =~ /(/`, "error parsing regexp: missing closing ): `(` at line 1, column 8"},
+		{`{a} == 1 AND {b} !~ "[a-"`, "error parsing regexp: missing closing ]: `[a-` at line 1, column 21"},
+	}
+	for _, test := range tests {
+		_, err := NewParser(strings.NewReader(test.cond)).Parse()
+		assert.EqualError(t, err, test.msg, test.cond)
+	}
+
+	expr, errs := NewParser(strings.NewReader(`{a} =~ /(/ OR {b} =~ /)/ OR {c}`)).ParseAll()
+	assert.Equal(t, "(OR (OR (=~ a <bad expression>) (=~ b <bad expression>)) c)", sexpr(expr))
+	assert.Len(t, errs, 2)
+}
+
+func TestPatternLiteral(t *testing.T) {
+	expr := mustParse(t, `{a} LIKE "Jo%" AND {b} NOT MATCHES "*.x" AND {c} ILIKE {pattern}`)
+	assert.Equal(t, `a LIKE "Jo%" AND b NOT MATCHES "*.x" AND c ILIKE pattern`, expr.String())
+
+	var patterns []string
+	WalkFunc(expr, func(n Node) {
+		if re, ok := n.(*RegexLiteral); ok {
+			patterns = append(patterns, re.Val.String())
+		}
+	})
+	assert.Equal(t, []string{`^(?s:Jo.*)$`, `^(?s:.*\.x)$`}, patterns)
+
+	// Only patterns from variables are compiled at evaluation.
+	r, err := Evaluate(expr, map[string]interface{}{"a": "John", "b": "a.y", "c": "ABC", "pattern": "a%"})
+	assert.NoError(t, err)
+	assert.True(t, r)
+	assert.Contains(t, regexCache.items, `(?i)^(?s:a.*)$`)
+	assert.NotContains(t, regexCache.items, `^(?s:Jo.*)$`)
+
+	var tests = []struct {
+		cond string
+		msg  string
+	}{
+		{`{a} MATCHES "api[z-a]"`, "invalid pattern \"api[z-a]\": error parsing regexp: invalid character class range: `z-a` at line 1, column 13"},
+		{`{a} == 1 OR {b} NOT ILIKE "%" AND {c} MATCHES "[b-a]"`, "invalid pattern \"[b-a]\": error parsing regexp: invalid character class range: `b-a` at line 1, column 47"},
+	}
+	for _, test := range tests {
+		_, err := NewParser(strings.NewReader(test.cond)).Parse()
+		assert.EqualError(t, err, test.msg, test.cond)
+	}
+}
@@ -40,39 +40,103 @@ const (
 
 	LPAREN // (
 	RPAREN // )
+
+	// Tokens added since follow, so that the values above do not change.
+
+	NOT           // NOT or !
+	ADD           // +
+	SUB           // -
+	MUL           // *
+	DIV           // /
+	MOD           // %
+	NAME          // function name: len, lower
+	COMMA         // ,
+	TIME          // 2024-01-01T00:00:00Z
+	DURATION      // 15m
+	BETWEEN       // BETWEEN
+	NOTBETWEEN    // NOT BETWEEN
+	STARTSWITH    // STARTS WITH
+	NOTSTARTSWITH // NOT STARTS WITH
+	ENDSWITH      // ENDS WITH
+	NOTENDSWITH   // NOT ENDS WITH
+	LIKE          // LIKE
+	NOTLIKE       // NOT LIKE
+	MATCHES       // MATCHES
+	NOTMATCHES    // NOT MATCHES
+	IEQ           // =*
+	INEQ          // !*
+	IIN           // IIN
+	NOTIIN        // NOT IIN
+	ILIKE         // ILIKE
+	NOTILIKE      // NOT ILIKE
+	NULL          // NULL
+	IS            // IS
+	ISNOT         // IS NOT
+	EXISTS        // EXISTS
 )
 
 var tokens = []string{
 	ILLEGAL: "ILLEGAL",
 	EOF:     "EOF",
 
-	IDENT:  "IDENT",
-	NUMBER: "NUMBER",
-	STRING: "STRING",
-	ARRAY:  "ARRAY",
-	TRUE:   "TRUE",
-	FALSE:  "FALSE",
-
-	AND: "AND",
-	OR:  "OR",
-	EQ:  "==",
-	NEQ: "!=",
-	LT:  "<",
-	LTE: "<=",
-	GT:  ">",
-	GTE: ">=",
-
-	NAND:        "NAND",
-	XOR:         "XOR",
-	EREG:        "=~",
-	NEREG:       "!~",
-	IN:          "IN",
-	NOTIN:       "NOT IN",
-	CONTAINS:    "CONTAINS",
-	NOTCONTAINS: "NOT CONTAINS",
+	IDENT:    "IDENT",
+	NUMBER:   "NUMBER",
+	STRING:   "STRING",
+	ARRAY:    "ARRAY",
+	TIME:     "TIME",
+	DURATION: "DURATION",
+	TRUE:     "TRUE",
+	FALSE:    "FALSE",
+	NULL:     "NULL",
+
+	AND:  "AND",
+	OR:   "OR",
+	EQ:   "==",
+	NEQ:  "!=",
+	IEQ:  "=*",
+	INEQ: "!*",
+	LT:   "<",
+	LTE:  "<=",
+	GT:   ">",
+	GTE:  ">=",
+
+	NAND:          "NAND",
+	XOR:           "XOR",
+	EREG:          "=~",
+	NEREG:         "!~",
+	IN:            "IN",
+	NOTIN:         "NOT IN",
+	IIN:           "IIN",
+	NOTIIN:        "NOT IIN",
+	CONTAINS:      "CONTAINS",
+	NOTCONTAINS:   "NOT CONTAINS",
+	BETWEEN:       "BETWEEN",
+	NOTBETWEEN:    "NOT BETWEEN",
+	IS:            "IS",
+	ISNOT:         "IS NOT",
+	STARTSWITH:    "STARTS WITH",
+	NOTSTARTSWITH: "NOT STARTS WITH",
+	ENDSWITH:      "ENDS WITH",
+	NOTENDSWITH:   "NOT ENDS WITH",
+	LIKE:          "LIKE",
+	NOTLIKE:       "NOT LIKE",
+	ILIKE:         "ILIKE",
+	NOTILIKE:      "NOT ILIKE",
+	MATCHES:       "MATCHES",
+	NOTMATCHES:    "NOT MATCHES",
+	ADD:           "+",
+	SUB:           "-",
+	MUL:           "*",
+	DIV:           "/",
+	MOD:           "%",
+
+	NOT:    "NOT",
+	EXISTS: "EXISTS",
+	NAME:   "NAME",
 
 	LPAREN: "(",
 	RPAREN: ")",
+	COMMA:  ",",
 }
 
 // String returns the string representation of the token.
@@ -83,6 +147,9 @@ func (tok Token) String() string {
 	return ""
 }
 
+// lowestPrecedence is the precedence of the loosest binding operators.
+const lowestPrecedence = 1
+
 // Precedence returns the operator precedence of the binary operator token.
 func (tok Token) Precedence() int {
 	switch tok {
@@ -90,14 +157,21 @@ func (tok Token) Precedence() int {
 		return 1
 	case AND, NAND:
 		return 2
-	case EQ, NEQ, LT, LTE, GT, GTE, IN, NOTIN, EREG, NEREG, CONTAINS, NOTCONTAINS:
+	case EQ, NEQ, IEQ, INEQ, LT, LTE, GT, GTE, IN, NOTIN, IIN, NOTIIN, EREG, NEREG, CONTAINS, NOTCONTAINS,
+		BETWEEN, NOTBETWEEN, IS, ISNOT, STARTSWITH, NOTSTARTSWITH, ENDSWITH, NOTENDSWITH, LIKE, NOTLIKE, ILIKE, NOTILIKE,
+		MATCHES, NOTMATCHES:
 		return 3
+	case ADD, SUB:
+		return 4
+	case MUL, DIV, MOD:
+		return 5
 	}
 	return 0
 }
 
-// isOperator returns true for operator tokens.
-func (tok Token) isOperator() bool { return tok > operatorBegin && tok < operatorEnd }
+// isOperator returns true for binary operator tokens, which are not all
+// between operatorBegin and operatorEnd.
+func (tok Token) isOperator() bool { return tok.Precedence() > 0 }
 
 // tokstr returns a literal if provided, otherwise returns the token string.
 func tokstr(tok Token, lit string) string {
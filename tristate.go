@@ -0,0 +1,157 @@
+package conditions
+
+import "fmt"
+
+// Tristate is the result of a three-valued evaluation.
+type Tristate int
+
+const (
+	// TristateUnknown is the result depending on missing variables.
+	TristateUnknown Tristate = iota
+	// TristateFalse is the result false.
+	TristateFalse
+	// TristateTrue is the result true.
+	TristateTrue
+)
+
+// String returns the string representation of the result.
+func (t Tristate) String() string {
+	switch t {
+	case TristateFalse:
+		return "false"
+	case TristateTrue:
+		return "true"
+	}
+	return "UNKNOWN"
+}
+
+// tristateOf returns the Tristate of the boolean.
+func tristateOf(b bool) Tristate {
+	if b {
+		return TristateTrue
+	}
+	return TristateFalse
+}
+
+// EvaluateTristate takes an expr and evaluates it using given args with
+// three-valued logic, see EvaluateTristateWithArgResolver.
+func EvaluateTristate(expr Expr, args map[string]interface{}, opts ...EvalOption) (Tristate, error) {
+	return EvaluateTristateWithArgResolver(expr, NewMapArgResolver(args), opts...)
+}
+
+// EvaluateTristateWithArgResolver takes an expr and evaluates it using
+// given arg resolver with three-valued logic. Variables without argument
+// or default are UNKNOWN instead of failing the evaluation, and so is
+// every operation on them, except for the logical operators which follow
+// Kleene's truth tables: "false AND UNKNOWN" is false and "true OR UNKNOWN"
+// is true. IS NULL and EXISTS are UNKNOWN for missing variables too, as
+// their arguments may just not have been fetched yet. The policy set with
+// WithMissingVariables is ignored.
+func EvaluateTristateWithArgResolver(expr Expr, args ArgResolver, opts ...EvalOption) (Tristate, error) {
+	return NewEvaluator(opts...).EvaluateTristate(expr, args)
+}
+
+// EvaluateTristate takes an expr and evaluates it using given arg resolver
+// with three-valued logic, see EvaluateTristateWithArgResolver.
+func (e *Evaluator) EvaluateTristate(expr Expr, args ArgResolver) (Tristate, error) {
+	ev, err := e.start(expr, args)
+	if err != nil {
+		return TristateUnknown, err
+	}
+	ev.tristate = true
+	result, err := ev.evaluateSubtree(expr)
+	if err != nil {
+		return TristateUnknown, err
+	}
+	switch n := result.(type) {
+	case *BooleanLiteral:
+		return tristateOf(n.Val), nil
+	case *unknownLiteral:
+		return TristateUnknown, nil
+	}
+	return TristateUnknown, fmt.Errorf("unexpected result of the root expression: %#v", result)
+}
+
+// unknownLiteral is the value of a missing variable in three-valued
+// evaluations.
+type unknownLiteral struct{}
+
+func (_ *unknownLiteral) node() {}
+func (_ *unknownLiteral) expr() {}
+
+// String returns a string representation of the literal.
+func (l *unknownLiteral) String() string {
+	return "UNKNOWN"
+}
+
+func (l *unknownLiteral) Args() []string {
+	args := []string{}
+	return args
+}
+
+var unknownExpr = &unknownLiteral{}
+
+// isUnknown reports whether e is UNKNOWN
+func isUnknown(e Expr) bool {
+	_, ok := e.(*unknownLiteral)
+	return ok
+}
+
+// getTristate returns the value of a boolean or UNKNOWN operand
+func getTristate(e Expr) (Tristate, error) {
+	if isUnknown(e) {
+		return TristateUnknown, nil
+	}
+	b, err := getBoolean(e)
+	if err != nil {
+		return TristateUnknown, err
+	}
+	return tristateOf(b), nil
+}
+
+// applyUnknown applies the operator to l/r operands of which at least one
+// is UNKNOWN
+func applyUnknown(op Token, l, r Expr) (Expr, error) {
+	switch op {
+	case AND, OR, XOR, NAND:
+	default:
+		return unknownExpr, nil
+	}
+
+	a, err := getTristate(l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := getTristate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var result Tristate
+	switch op {
+	case AND, NAND:
+		if a == TristateFalse || b == TristateFalse {
+			result = TristateFalse
+		} else if a == TristateTrue && b == TristateTrue {
+			result = TristateTrue
+		}
+		if op == NAND && result != TristateUnknown {
+			result = tristateOf(result == TristateFalse)
+		}
+	case OR:
+		if a == TristateTrue || b == TristateTrue {
+			result = TristateTrue
+		} else if a == TristateFalse && b == TristateFalse {
+			result = TristateFalse
+		}
+	case XOR:
+		if a != TristateUnknown && b != TristateUnknown {
+			result = tristateOf(a != b)
+		}
+	}
+
+	if result == TristateUnknown {
+		return unknownExpr, nil
+	}
+	return &BooleanLiteral{Val: result == TristateTrue}, nil
+}
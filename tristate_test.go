@@ -0,0 +1,98 @@
+package conditions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateTristate(t *testing.T) {
+	var tests = []struct {
+		cond   string
+		args   map[string]interface{}
+		result Tristate
+		isErr  bool
+	}{
+		{`{a} == 1`, map[string]interface{}{"a": 1}, TristateTrue, false},
+		{`{a} == 1`, map[string]interface{}{"a": 2}, TristateFalse, false},
+		{`{a} == 1`, nil, TristateUnknown, false},
+		{`{a} + 1 > 2`, nil, TristateUnknown, false},
+		{`len({a}) > 2`, nil, TristateUnknown, false},
+		{`{a} BETWEEN 1 AND {b}`, map[string]interface{}{"a": 1}, TristateUnknown, false},
+		{`NOT {a} == 1`, nil, TristateUnknown, false},
+		{`{a} IS NULL`, nil, TristateUnknown, false},
+		{`EXISTS {a}`, nil, TristateUnknown, false},
+		{`{a} IS NULL`, map[string]interface{}{"a": nil}, TristateTrue, false},
+
+		{`{a} == 1 AND {b} == 1`, map[string]interface{}{"b": 2}, TristateFalse, false},
+		{`{a} == 1 AND {b} == 1`, map[string]interface{}{"b": 1}, TristateUnknown, false},
+		{`{a} == 1 AND {b} == 1`, nil, TristateUnknown, false},
+		{`{a} == 1 OR {b} == 1`, map[string]interface{}{"b": 1}, TristateTrue, false},
+		{`{a} == 1 OR {b} == 1`, map[string]interface{}{"b": 2}, TristateUnknown, false},
+		{`{a} == 1 XOR {b} == 1`, map[string]interface{}{"b": 1}, TristateUnknown, false},
+		{`{a} == 1 NAND {b} == 1`, map[string]interface{}{"b": 2}, TristateTrue, false},
+		{`{a} == 1 NAND {b} == 1`, map[string]interface{}{"b": 1}, TristateUnknown, false},
+		{`({a} == 1 OR {b} == 1) AND {c} == 1`, map[string]interface{}{"b": 1, "c": 1}, TristateTrue, false},
+		{`NOT ({a} == 1 AND {b} == 1)`, map[string]interface{}{"b": 2}, TristateTrue, false},
+
+		{`{a} == 1 OR {b} > "x"`, map[string]interface{}{"b": 1}, TristateUnknown, true},
+		{`{a} AND {b}`, map[string]interface{}{"b": 1}, TristateUnknown, true},
+	}
+
+	for _, test := range tests {
+		r, err := EvaluateTristate(mustParse(t, test.cond), test.args)
+		assert.Equal(t, test.result, r, test.cond)
+		if test.isErr {
+			assert.Error(t, err, test.cond)
+		} else {
+			assert.NoError(t, err, test.cond)
+		}
+	}
+
+	// Defaults are arguments, the missing variable policy does not apply.
+	r, err := EvaluateTristate(mustParse(t, `{a} == 1 AND {b} == 2`), nil,
+		WithDefaults(map[string]interface{}{"a": 1}), WithMissingVariables(MissingVariableFalse))
+	assert.NoError(t, err)
+	assert.Equal(t, TristateUnknown, r)
+	assert.Equal(t, "UNKNOWN", r.String())
+}
+
+func TestKleeneTruthTables(t *testing.T) {
+	values := map[Tristate]string{TristateFalse: "false", TristateTrue: "true", TristateUnknown: "{u}"}
+	var tests = []struct {
+		op    Token
+		table [3][3]Tristate // indexed by Tristate
+	}{
+		{AND, [3][3]Tristate{
+			TristateUnknown: {TristateUnknown, TristateFalse, TristateUnknown},
+			TristateFalse:   {TristateFalse, TristateFalse, TristateFalse},
+			TristateTrue:    {TristateUnknown, TristateFalse, TristateTrue},
+		}},
+		{OR, [3][3]Tristate{
+			TristateUnknown: {TristateUnknown, TristateUnknown, TristateTrue},
+			TristateFalse:   {TristateUnknown, TristateFalse, TristateTrue},
+			TristateTrue:    {TristateTrue, TristateTrue, TristateTrue},
+		}},
+		{XOR, [3][3]Tristate{
+			TristateUnknown: {TristateUnknown, TristateUnknown, TristateUnknown},
+			TristateFalse:   {TristateUnknown, TristateFalse, TristateTrue},
+			TristateTrue:    {TristateUnknown, TristateTrue, TristateFalse},
+		}},
+		{NAND, [3][3]Tristate{
+			TristateUnknown: {TristateUnknown, TristateTrue, TristateUnknown},
+			TristateFalse:   {TristateTrue, TristateTrue, TristateTrue},
+			TristateTrue:    {TristateUnknown, TristateTrue, TristateFalse},
+		}},
+	}
+
+	for _, test := range tests {
+		for a, row := range test.table {
+			for b, want := range row {
+				cond := values[Tristate(a)] + " " + test.op.String() + " " + values[Tristate(b)]
+				r, err := EvaluateTristate(mustParse(t, cond), nil)
+				assert.NoError(t, err, cond)
+				assert.Equal(t, want, r, cond)
+			}
+		}
+	}
+}